@@ -0,0 +1,430 @@
+package webpwrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// animFrame is a single frame of a WebP animation as stored in an ANMF chunk.
+type animFrame struct {
+	x        int    // Horizontal offset of the frame on the canvas in pixels
+	y        int    // Vertical offset of the frame on the canvas in pixels
+	width    int    // Width of the frame in pixels
+	height   int    // Height of the frame in pixels
+	duration int    // Display duration of the frame in milliseconds
+	blend    bool   // Whether the frame is alpha-blended with the canvas
+	dispose  bool   // Whether the frame area is cleared to the background after display
+	alpha    []byte // Payload of the ALPH chunk, if any
+	fourCC   string // FourCC of the image bitstream, "VP8 " or "VP8L"
+	data     []byte // Payload of the image bitstream chunk
+}
+
+// animation is a WebP animation as stored in the file.
+type animation struct {
+	width      int         // Width of the canvas in pixels
+	height     int         // Height of the canvas in pixels
+	background [4]byte     // Background color in the BGRA order of the ANIM chunk
+	loopCount  int         // Number of times to loop the animation, 0 for infinite
+	frames     []animFrame // Frames in display order
+}
+
+// readAnimation reads the canvas, the global parameters and the frames of a WebP animation from r.
+// Returns an error if the file is not an animation.
+func readAnimation(r io.Reader) (*animation, error) {
+	var anim *animation
+
+	err := walkChunks(r, func(fourCC string, size uint32, payload io.Reader) error {
+		switch fourCC {
+		case "VP8X":
+			var data [10]byte
+			if _, err := io.ReadFull(payload, data[:]); err != nil {
+				return fmt.Errorf("invalid VP8X chunk: %w", err)
+			}
+			if data[0]&vp8xFlagAnimation == 0 {
+				return errStopWalk
+			}
+			anim = &animation{
+				width:  int(uint32(data[4])|uint32(data[5])<<8|uint32(data[6])<<16) + 1,
+				height: int(uint32(data[7])|uint32(data[8])<<8|uint32(data[9])<<16) + 1,
+			}
+		case "VP8 ", "VP8L":
+			if anim == nil {
+				return errStopWalk
+			}
+		case "ANIM":
+			if anim == nil {
+				return nil
+			}
+			var data [6]byte
+			if _, err := io.ReadFull(payload, data[:]); err != nil {
+				return fmt.Errorf("invalid ANIM chunk: %w", err)
+			}
+			copy(anim.background[:], data[0:4])
+			anim.loopCount = int(binary.LittleEndian.Uint16(data[4:6]))
+		case "ANMF":
+			if anim == nil {
+				return nil
+			}
+			data, err := io.ReadAll(payload)
+			if err != nil {
+				return fmt.Errorf("failed to read ANMF chunk: %w", err)
+			}
+			frame, err := parseAnimFrame(data)
+			if err != nil {
+				return err
+			}
+			anim.frames = append(anim.frames, frame)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if anim == nil {
+		return nil, errors.New("not an animated WebP image")
+	}
+	return anim, nil
+}
+
+// parseAnimFrame parses the payload of an ANMF chunk.
+func parseAnimFrame(data []byte) (animFrame, error) {
+	if len(data) < 16 {
+		return animFrame{}, fmt.Errorf("invalid ANMF chunk: %d bytes", len(data))
+	}
+
+	uint24 := func(b []byte) int {
+		return int(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16)
+	}
+	frame := animFrame{
+		x:        uint24(data[0:3]) * 2,
+		y:        uint24(data[3:6]) * 2,
+		width:    uint24(data[6:9]) + 1,
+		height:   uint24(data[9:12]) + 1,
+		duration: uint24(data[12:15]),
+		blend:    data[15]&0x02 == 0,
+		dispose:  data[15]&0x01 != 0,
+	}
+
+	if err := frame.parseData(data[16:]); err != nil {
+		return animFrame{}, fmt.Errorf("invalid ANMF chunk: %w", err)
+	}
+	return frame, nil
+}
+
+// parseData reads the image data of the frame from a list of chunks,
+// consisting of an optional ALPH chunk, the image bitstream and unknown chunks.
+func (f *animFrame) parseData(data []byte) error {
+	for offset := 0; offset+8 <= len(data); {
+		fourCC := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		start := offset + 8
+		if size > len(data)-start {
+			return fmt.Errorf("chunk %q exceeds the frame data", fourCC)
+		}
+
+		switch fourCC {
+		case "ALPH":
+			f.alpha = data[start : start+size]
+		case "VP8 ", "VP8L":
+			if f.fourCC == "" {
+				f.fourCC, f.data = fourCC, data[start:start+size]
+			}
+		}
+		offset = start + size + size&1
+	}
+
+	if f.fourCC == "" {
+		return errors.New("missing image bitstream")
+	}
+	return nil
+}
+
+// hasAlpha reports whether the frame has an alpha channel.
+func (f animFrame) hasAlpha() bool {
+	if f.fourCC == "VP8L" {
+		_, _, alpha, err := readVP8LHeader(bytes.NewReader(f.data))
+		return err == nil && alpha
+	}
+	return f.alpha != nil
+}
+
+// writeData writes the image data chunks of the frame.
+func (f animFrame) writeData(w *bytes.Buffer) {
+	if f.alpha != nil && f.fourCC == "VP8 " {
+		writeChunk(w, "ALPH", f.alpha)
+	}
+	writeChunk(w, f.fourCC, f.data)
+}
+
+// webp returns the frame as a standalone WebP file, like webpmux -get frame.
+func (f animFrame) webp() []byte {
+	var body bytes.Buffer
+	if f.alpha != nil && f.fourCC == "VP8 " {
+		writeChunk(&body, "VP8X", vp8xHeader(vp8xFlagAlpha, f.width, f.height))
+	}
+	f.writeData(&body)
+	return riffFile(body.Bytes())
+}
+
+// webp returns the animation as a WebP file.
+func (a *animation) webp() []byte {
+	var flags byte = vp8xFlagAnimation
+	for _, f := range a.frames {
+		if f.hasAlpha() {
+			flags |= vp8xFlagAlpha
+		}
+	}
+
+	var body bytes.Buffer
+	writeChunk(&body, "VP8X", vp8xHeader(flags, a.width, a.height))
+
+	global := make([]byte, 6)
+	copy(global[0:4], a.background[:])
+	binary.LittleEndian.PutUint16(global[4:6], uint16(a.loopCount))
+	writeChunk(&body, "ANIM", global)
+
+	for _, f := range a.frames {
+		var frame bytes.Buffer
+		put24 := func(v int) {
+			frame.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16)})
+		}
+		put24(f.x / 2)
+		put24(f.y / 2)
+		put24(f.width - 1)
+		put24(f.height - 1)
+		put24(f.duration)
+		var frameFlags byte
+		if !f.blend {
+			frameFlags |= 0x02
+		}
+		if f.dispose {
+			frameFlags |= 0x01
+		}
+		frame.WriteByte(frameFlags)
+		f.writeData(&frame)
+		writeChunk(&body, "ANMF", frame.Bytes())
+	}
+
+	return riffFile(body.Bytes())
+}
+
+// vp8xHeader returns the payload of a VP8X chunk with the given flags and canvas size.
+func vp8xHeader(flags byte, width, height int) []byte {
+	header := make([]byte, 10)
+	header[0] = flags
+	w, h := uint32(width-1), uint32(height-1)
+	header[4], header[5], header[6] = byte(w), byte(w>>8), byte(w>>16)
+	header[7], header[8], header[9] = byte(h), byte(h>>8), byte(h>>16)
+	return header
+}
+
+// writeChunk writes a RIFF chunk including its padding byte.
+func writeChunk(w *bytes.Buffer, fourCC string, data []byte) {
+	w.WriteString(fourCC)
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+	if len(data)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+// riffFile wraps the chunks of a WebP file into the RIFF header.
+func riffFile(chunks []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(len(chunks)+4))
+	b.WriteString("WEBP")
+	b.Write(chunks)
+	return b.Bytes()
+}
+
+// DecodeFrame reads an animated WebP image from r and decodes the frame with the given index.
+// Only the requested frame is decoded. The frame is returned as stored in the animation,
+// like webpmux -get frame: with its own dimensions, without its canvas offset and
+// without blending it onto the previous frames.
+// It is a convenience function that uses the backend set with SetBackend, which wraps the DWebP decoder by default.
+//
+// Parameters:
+//   - r: The io.Reader containing the animated WebP image data
+//   - index: The zero-based index of the frame
+//
+// Returns:
+//   - image.Image: The decoded frame
+//   - error: Any error encountered during decoding, or if the index is out of range
+func DecodeFrame(r io.Reader, index int) (image.Image, error) {
+	return DecodeFrameWithContext(context.Background(), r, index)
+}
+
+// DecodeFrameWithContext reads an animated WebP image from r and decodes the frame with the given index.
+// The context can be used to cancel the operation. See DecodeFrame.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - r: The io.Reader containing the animated WebP image data
+//   - index: The zero-based index of the frame
+//
+// Returns:
+//   - image.Image: The decoded frame
+//   - error: Any error encountered during decoding, or if the index is out of range
+func DecodeFrameWithContext(ctx context.Context, r io.Reader, index int) (image.Image, error) {
+	anim, err := readAnimation(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP animation: %w", err)
+	}
+
+	if index < 0 || index >= len(anim.frames) {
+		return nil, fmt.Errorf("frame index %d out of range: the animation has %d frames", index, len(anim.frames))
+	}
+
+	img, err := backend().Decode(ctx, bytes.NewReader(anim.frames[index].webp()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode frame %d: %w", index, err)
+	}
+	return img, nil
+}
+
+// ProcessAnimation decodes the frames of an animated WebP image from r, applies transform to each frame
+// and re-assembles the animation, preserving the frame durations, offsets, blending and disposal methods,
+// the loop count and the background color. Metadata chunks such as ICCP, EXIF or XMP are not carried over.
+// The transform is called in display order with the zero-based frame index and the frame as stored,
+// like in DecodeFrame: frames are not composited. The transformed frame must fit into the canvas
+// at the offset of the frame. Lossless frames are re-encoded losslessly, lossy frames with the
+// default quality of 75.
+// Encoding and decoding use the backend set with SetBackend, which wraps the cwebp and dwebp tools by default.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - r: The io.Reader containing the animated WebP image data
+//   - transform: The function transforming each frame
+//
+// Returns:
+//   - io.Reader: The re-assembled animated WebP image
+//   - error: Any error encountered while decoding, transforming or encoding a frame
+func ProcessAnimation(ctx context.Context, r io.Reader, transform func(int, image.Image) (image.Image, error)) (io.Reader, error) {
+	anim, err := readAnimation(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP animation: %w", err)
+	}
+
+	for i := range anim.frames {
+		frame := &anim.frames[i]
+
+		img, err := backend().Decode(ctx, bytes.NewReader(frame.webp()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode frame %d: %w", i, err)
+		}
+
+		if img, err = transform(i, img); err != nil {
+			return nil, fmt.Errorf("failed to transform frame %d: %w", i, err)
+		}
+
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+		if width <= 0 || height <= 0 || frame.x+width > anim.width || frame.y+height > anim.height {
+			return nil, fmt.Errorf("transformed frame %d of %dx%d pixels at %d,%d exceeds the %dx%d canvas",
+				i, width, height, frame.x, frame.y, anim.width, anim.height)
+		}
+
+		var encoded bytes.Buffer
+		opts := &Encoder{Quality: 75, Lossless: frame.fourCC == "VP8L"}
+		if err := backend().Encode(ctx, &encoded, img, opts); err != nil {
+			return nil, fmt.Errorf("failed to encode frame %d: %w", i, err)
+		}
+
+		data := encoded.Bytes()
+		if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+			return nil, fmt.Errorf("failed to read encoded frame %d: not a WebP file", i)
+		}
+
+		*frame = animFrame{
+			x:        frame.x,
+			y:        frame.y,
+			width:    width,
+			height:   height,
+			duration: frame.duration,
+			blend:    frame.blend,
+			dispose:  frame.dispose,
+		}
+		// The chunks of the encoded file hold the frame data; VP8X and metadata chunks are skipped.
+		if err := frame.parseData(data[12:]); err != nil {
+			return nil, fmt.Errorf("failed to read encoded frame %d: %w", i, err)
+		}
+	}
+
+	return bytes.NewReader(anim.webp()), nil
+}
+
+// SplitAnimation reads an animated WebP image from r and writes every frame to outDir as a static WebP file.
+// Each frame is composited onto the canvas as a viewer would display it, honoring the offsets,
+// blending and disposal methods, so each file holds the full canvas at that point of the animation.
+// Like libwebp's animation decoder, the canvas starts out transparent and disposed frames are
+// cleared to transparent. The files are named frame_<index>.webp with the zero-based index padded
+// to a common width, so they sort in display order. Frames of lossless animations are encoded
+// losslessly, others with the default quality of 75. The directory is created if necessary.
+// Encoding and decoding use the backend set with SetBackend, which wraps the cwebp and dwebp tools by default.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - r: The io.Reader containing the animated WebP image data
+//   - outDir: The directory to write the frames to
+//
+// Returns:
+//   - []string: The paths of the written files in display order
+//   - error: Any error encountered while decoding, encoding or writing a frame
+func SplitAnimation(ctx context.Context, r io.Reader, outDir string) ([]string, error) {
+	anim, err := readAnimation(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP animation: %w", err)
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	digits := len(strconv.Itoa(max(len(anim.frames)-1, 0)))
+	canvas := image.NewNRGBA(image.Rect(0, 0, anim.width, anim.height))
+	paths := make([]string, 0, len(anim.frames))
+
+	for i, frame := range anim.frames {
+		if i > 0 && anim.frames[i-1].dispose {
+			previous := anim.frames[i-1]
+			rect := image.Rect(previous.x, previous.y, previous.x+previous.width, previous.y+previous.height)
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		}
+
+		img, err := backend().Decode(ctx, bytes.NewReader(frame.webp()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode frame %d: %w", i, err)
+		}
+
+		op := draw.Src
+		if frame.blend {
+			op = draw.Over
+		}
+		rect := image.Rect(frame.x, frame.y, frame.x+frame.width, frame.y+frame.height)
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+
+		var encoded bytes.Buffer
+		opts := &Encoder{Quality: 75, Lossless: frame.fourCC == "VP8L"}
+		if err := backend().Encode(ctx, &encoded, canvas, opts); err != nil {
+			return nil, fmt.Errorf("failed to encode frame %d: %w", i, err)
+		}
+
+		path := filepath.Join(outDir, fmt.Sprintf("frame_%0*d.webp", digits, i))
+		if err := os.WriteFile(path, encoded.Bytes(), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write frame %d: %w", i, err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
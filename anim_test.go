@@ -0,0 +1,249 @@
+package webpwrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+// encodeBitstream encodes a uniformly colored image losslessly and returns its bitstream chunk.
+func encodeBitstream(t *testing.T, c color.Color, width, height int) testChunk {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var encoded bytes.Buffer
+	err := NewCWebP().Lossless().InputImage(img).Output(&encoded).Run()
+	assert.Nil(t, err)
+
+	var chunk testChunk
+	err = walkChunks(&encoded, func(fourCC string, size uint32, payload io.Reader) error {
+		if fourCC == "VP8 " || fourCC == "VP8L" {
+			data, err := io.ReadAll(payload)
+			chunk = testChunk{fourCC, data}
+			return err
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	return chunk
+}
+
+// anmfChunk builds an ANMF chunk holding the given frame data chunks.
+func anmfChunk(x, y, width, height, duration int, flags byte, chunks ...testChunk) testChunk {
+	data := make([]byte, 16)
+	put24 := func(b []byte, v int) {
+		b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+	}
+	put24(data[0:3], x/2)
+	put24(data[3:6], y/2)
+	put24(data[6:9], width-1)
+	put24(data[9:12], height-1)
+	put24(data[12:15], duration)
+	data[15] = flags
+
+	// The frame data chunks are laid out like the chunks of a RIFF file.
+	riff := buildRIFF(chunks...)
+	return testChunk{"ANMF", append(data, riff[12:]...)}
+}
+
+// buildAnimation builds an animation of uniformly colored frames.
+func buildAnimation(t *testing.T, width, height int, colors ...color.Color) []byte {
+	// Default ANIM chunk: transparent background, infinite loop.
+	chunks := []testChunk{vp8xChunk(vp8xFlagAnimation, width, height), {"ANIM", make([]byte, 6)}}
+	for _, c := range colors {
+		chunks = append(chunks, anmfChunk(0, 0, width, height, 100, 0, encodeBitstream(t, c, width, height)))
+	}
+	return buildRIFF(chunks...)
+}
+
+func TestDecodeFrame(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	data := buildAnimation(t, 16, 12, red, green, blue)
+
+	first, err := DecodeFrame(bytes.NewReader(data), 0)
+	assert.Nil(t, err)
+	middle, err := DecodeFrame(bytes.NewReader(data), 1)
+	assert.Nil(t, err)
+
+	assert.Equal(t, image.Rect(0, 0, 16, 12), middle.Bounds())
+	assert.Equal(t, red, color.NRGBAModel.Convert(first.At(8, 6)))
+	assert.Equal(t, green, color.NRGBAModel.Convert(middle.At(8, 6)))
+	assert.NotEqual(t, first.At(8, 6), middle.At(8, 6))
+
+	_, err = DecodeFrame(bytes.NewReader(data), 3)
+	assert.ErrorContains(t, err, "out of range")
+	_, err = DecodeFrame(bytes.NewReader(data), -1)
+	assert.ErrorContains(t, err, "out of range")
+
+	_, err = DecodeFrame(strings.NewReader("not a webp image"), 0)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeFrameStillImage(t *testing.T) {
+	data := buildRIFF(encodeBitstream(t, color.Black, 4, 4))
+	_, err := DecodeFrame(bytes.NewReader(data), 0)
+	assert.ErrorContains(t, err, "not an animated")
+}
+
+func TestProcessAnimation(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	global := []byte{1, 2, 3, 4, 3, 0} // BGRA background and a loop count of 3
+	data := buildRIFF(
+		vp8xChunk(vp8xFlagAnimation, 16, 12),
+		testChunk{"ANIM", global},
+		anmfChunk(0, 0, 16, 12, 100, 0, encodeBitstream(t, red, 16, 12)),
+		anmfChunk(4, 2, 8, 6, 250, 0x03, encodeBitstream(t, green, 8, 6)),
+		anmfChunk(0, 0, 16, 12, 40, 0, encodeBitstream(t, blue, 16, 12)),
+	)
+	original, err := readAnimation(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	var indices []int
+	r, err := ProcessAnimation(context.Background(), bytes.NewReader(data), func(i int, img image.Image) (image.Image, error) {
+		indices = append(indices, i)
+		return img, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2}, indices)
+
+	processed, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	v, err := Validate(bytes.NewReader(processed))
+	assert.Nil(t, err)
+	assert.True(t, v.Valid, v.Problems)
+	assert.Equal(t, FormatAnimated, v.Format)
+
+	anim, err := readAnimation(bytes.NewReader(processed))
+	assert.Nil(t, err)
+	assert.Equal(t, 16, anim.width)
+	assert.Equal(t, 12, anim.height)
+	assert.Equal(t, [4]byte{1, 2, 3, 4}, anim.background)
+	assert.Equal(t, 3, anim.loopCount)
+	assert.Len(t, anim.frames, 3)
+	for i, f := range anim.frames {
+		o := original.frames[i]
+		assert.Equal(t, []int{o.x, o.y, o.width, o.height, o.duration}, []int{f.x, f.y, f.width, f.height, f.duration})
+		assert.Equal(t, o.blend, f.blend)
+		assert.Equal(t, o.dispose, f.dispose)
+	}
+	assert.False(t, anim.frames[1].blend)
+	assert.True(t, anim.frames[1].dispose)
+
+	frame, err := DecodeFrame(bytes.NewReader(processed), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, green, color.NRGBAModel.Convert(frame.At(2, 2)))
+}
+
+func TestProcessAnimationErrors(t *testing.T) {
+	data := buildAnimation(t, 8, 8, color.Black, color.White)
+	ctx := context.Background()
+
+	// Transformed frames must fit into the canvas.
+	_, err := ProcessAnimation(ctx, bytes.NewReader(data), func(i int, img image.Image) (image.Image, error) {
+		return image.NewNRGBA(image.Rect(0, 0, 16, 8)), nil
+	})
+	assert.ErrorContains(t, err, "exceeds the 8x8 canvas")
+
+	errTransform := errors.New("transform failed")
+	_, err = ProcessAnimation(ctx, bytes.NewReader(data), func(i int, img image.Image) (image.Image, error) {
+		return nil, errTransform
+	})
+	assert.ErrorIs(t, err, errTransform)
+
+	_, err = ProcessAnimation(ctx, bytes.NewReader(buildRIFF(encodeBitstream(t, color.Black, 4, 4))),
+		func(i int, img image.Image) (image.Image, error) { return img, nil })
+	assert.ErrorContains(t, err, "not an animated")
+}
+
+func TestSplitAnimation(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	data := buildRIFF(
+		vp8xChunk(vp8xFlagAnimation, 16, 12),
+		testChunk{"ANIM", make([]byte, 6)},
+		anmfChunk(0, 0, 16, 12, 100, 0, encodeBitstream(t, red, 16, 12)),
+		anmfChunk(4, 2, 8, 6, 100, 0x01, encodeBitstream(t, green, 8, 6)),
+		anmfChunk(0, 0, 4, 4, 100, 0, encodeBitstream(t, blue, 4, 4)),
+	)
+
+	dir := filepath.Join(t.TempDir(), "frames")
+	paths, err := SplitAnimation(context.Background(), bytes.NewReader(data), dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "frame_0.webp"),
+		filepath.Join(dir, "frame_1.webp"),
+		filepath.Join(dir, "frame_2.webp"),
+	}, paths)
+
+	var frames []image.Image
+	for _, path := range paths {
+		f, err := os.Open(path)
+		assert.Nil(t, err)
+		v, err := Validate(f)
+		f.Close()
+		assert.Nil(t, err)
+		assert.True(t, v.Valid, v.Problems)
+		assert.NotEqual(t, FormatAnimated, v.Format)
+
+		f, err = os.Open(path)
+		assert.Nil(t, err)
+		img, err := webp.Decode(f)
+		f.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, 16, 12), img.Bounds())
+		frames = append(frames, img)
+	}
+
+	// The frames are composited onto the canvas.
+	assert.Equal(t, red, color.NRGBAModel.Convert(frames[0].At(8, 6)))
+	assert.Equal(t, green, color.NRGBAModel.Convert(frames[1].At(8, 6)))
+	assert.Equal(t, red, color.NRGBAModel.Convert(frames[1].At(0, 0)))
+	// The second frame is disposed before the third one is drawn.
+	assert.Equal(t, color.NRGBA{}, color.NRGBAModel.Convert(frames[2].At(8, 6)))
+	assert.Equal(t, blue, color.NRGBAModel.Convert(frames[2].At(1, 1)))
+	assert.Equal(t, red, color.NRGBAModel.Convert(frames[2].At(15, 11)))
+}
+
+func TestSplitAnimationBackend(t *testing.T) {
+	data := buildRIFF(
+		vp8xChunk(vp8xFlagAnimation, 4, 4),
+		testChunk{"ANIM", make([]byte, 6)},
+		anmfChunk(0, 0, 4, 4, 100, 0, encodeBitstream(t, color.NRGBA{255, 0, 0, 255}, 4, 4)),
+		anmfChunk(0, 0, 4, 4, 100, 0, encodeBitstream(t, color.NRGBA{0, 255, 0, 255}, 4, 4)),
+	)
+
+	recorder := &recordingBackend{}
+	SetBackend(recorder)
+	defer SetBackend(nil)
+
+	paths, err := SplitAnimation(context.Background(), bytes.NewReader(data), t.TempDir())
+	assert.Nil(t, err)
+	assert.Len(t, paths, 2)
+	assert.Equal(t, 2, recorder.encodes)
+	assert.Equal(t, 2, recorder.decodes)
+
+	for _, path := range paths {
+		written, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "webp", string(written))
+	}
+}
@@ -0,0 +1,68 @@
+package webpwrap
+
+import (
+	"context"
+	"image"
+	"io"
+	"sync"
+)
+
+// Backend encodes and decodes WebP images.
+// Encoders use the backend set in their Backend field. The package-level Encode and Decode functions,
+// Encoders without a backend and Cache use the default backend set with SetBackend.
+// Implementations must be safe for concurrent use.
+type Backend interface {
+	// Encode writes the Image m to w in WebP format using the given options.
+	Encode(ctx context.Context, w io.Writer, m image.Image, opts *Encoder) error
+
+	// Decode reads a WebP image from r.
+	Decode(ctx context.Context, r io.Reader) (image.Image, error)
+}
+
+// defaultBackend is the Backend used by Encode, Decode and Encoders without a backend.
+var defaultBackend = struct {
+	sync.RWMutex
+	b Backend
+}{b: processBackend{}}
+
+// SetBackend selects the default Backend, which is used by Encode, Decode, Cache and Encoders
+// without a backend of their own. To use a backend for some encodes only, set Encoder.Backend instead.
+// The default is ProcessBackend, which runs the cwebp and dwebp binaries.
+// Passing nil restores the default.
+// SetBackend is safe to call concurrently with encode or decode operations; operations that
+// already started keep using the previous backend.
+func SetBackend(b Backend) {
+	if b == nil {
+		b = processBackend{}
+	}
+	defaultBackend.Lock()
+	defaultBackend.b = b
+	defaultBackend.Unlock()
+}
+
+// backend returns the default Backend set with SetBackend.
+func backend() Backend {
+	defaultBackend.RLock()
+	defer defaultBackend.RUnlock()
+	return defaultBackend.b
+}
+
+// ProcessBackend returns the Backend running the cwebp and dwebp binaries.
+func ProcessBackend() Backend {
+	return processBackend{}
+}
+
+// processBackend encodes and decodes images by running the cwebp and dwebp binaries.
+type processBackend struct{}
+
+func (processBackend) Encode(ctx context.Context, w io.Writer, m image.Image, opts *Encoder) error {
+	c := NewCWebP().Quality(opts.Quality)
+	if opts.Lossless {
+		c.Lossless()
+	}
+	return c.InputImage(m).Output(w).RunWithContext(ctx)
+}
+
+func (processBackend) Decode(ctx context.Context, r io.Reader) (image.Image, error) {
+	return NewDWebP().Input(r).RunWithContext(ctx)
+}
@@ -0,0 +1,106 @@
+//go:build cgo && webp_cgo
+
+package webpwrap
+
+/*
+#cgo pkg-config: libwebp
+#include <stdlib.h>
+#include <webp/decode.h>
+#include <webp/encode.h>
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+// cgoBackend encodes and decodes images with libwebp linked through cgo.
+type cgoBackend struct{}
+
+// NewCgoBackend returns a Backend calling libwebp directly instead of running the binaries,
+// which avoids the process startup and the PNG intermediate of every operation.
+// It is only available when building with cgo and the webp_cgo build tag, e.g.
+// go build -tags webp_cgo, which requires the libwebp development files.
+// The libwebp calls can't be interrupted, so the context is only checked before and after them.
+// Only the Quality and Lossless settings of the Encoder are supported.
+func NewCgoBackend() (Backend, error) {
+	return cgoBackend{}, nil
+}
+
+func (cgoBackend) Encode(ctx context.Context, w io.Writer, m image.Image, opts *Encoder) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+
+	if err := checkImageSize(m); err != nil {
+		return err
+	}
+	bounds := m.Bounds()
+
+	// libwebp expects non-premultiplied RGBA samples.
+	img, ok := m.(*image.NRGBA)
+	if !ok || img.Rect.Min != (image.Point{}) {
+		img = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(img, img.Bounds(), m, bounds.Min, draw.Src)
+	}
+
+	var output *C.uint8_t
+	pix := (*C.uint8_t)(unsafe.Pointer(&img.Pix[0]))
+	width, height, stride := C.int(bounds.Dx()), C.int(bounds.Dy()), C.int(img.Stride)
+
+	var size C.size_t
+	if opts.Lossless {
+		size = C.WebPEncodeLosslessRGBA(pix, width, height, stride, &output)
+	} else {
+		quality := C.float(min(opts.Quality, 100))
+		size = C.WebPEncodeRGBA(pix, width, height, stride, quality, &output)
+	}
+	if size == 0 {
+		return errors.New("libwebp failed to encode image")
+	}
+	defer C.WebPFree(unsafe.Pointer(output))
+
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+
+	if _, err := w.Write(C.GoBytes(unsafe.Pointer(output), C.int(size))); err != nil {
+		return fmt.Errorf("%w: %w", ErrOutputWrite, err)
+	}
+	return nil
+}
+
+func (cgoBackend) Decode(ctx context.Context, r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty input")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+
+	var width, height C.int
+	pix := C.WebPDecodeRGBA((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &width, &height)
+	if pix == nil {
+		return nil, errors.New("libwebp failed to decode image")
+	}
+	defer C.WebPFree(unsafe.Pointer(pix))
+
+	img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
+	copy(img.Pix, unsafe.Slice((*byte)(unsafe.Pointer(pix)), len(img.Pix)))
+
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+	return img, nil
+}
@@ -0,0 +1,26 @@
+//go:build cgo && webp_cgo
+
+package webpwrap
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func TestCgoBackend(t *testing.T) {
+	b, err := NewCgoBackend()
+	assert.Nil(t, err)
+	testBackend(t, b)
+
+	// The backend can be selected for a single encoder.
+	var out bytes.Buffer
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	assert.Nil(t, (&Encoder{Quality: 80, Backend: b}).Encode(&out, img))
+	decoded, err := webp.Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), decoded.Bounds())
+}
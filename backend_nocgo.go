@@ -0,0 +1,12 @@
+//go:build !cgo || !webp_cgo
+
+package webpwrap
+
+import "errors"
+
+// NewCgoBackend returns a Backend calling libwebp directly instead of running the binaries.
+// This build doesn't include it; build with cgo and the webp_cgo build tag, e.g.
+// go build -tags webp_cgo, which requires the libwebp development files.
+func NewCgoBackend() (Backend, error) {
+	return nil, errors.New("cgo backend not available: build with cgo and the webp_cgo tag")
+}
@@ -0,0 +1,135 @@
+package webpwrap
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+// testBackend checks that the backend produces decodable output and decodes its own output.
+func testBackend(t *testing.T, b Backend) {
+	img := image.NewNRGBA(image.Rect(0, 0, 24, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 24; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{byte(x * 10), byte(y * 15), 128, 255})
+		}
+	}
+	ctx := context.Background()
+
+	for _, opts := range []*Encoder{{Quality: 80}, {Lossless: true}} {
+		var out bytes.Buffer
+		assert.Nil(t, b.Encode(ctx, &out, img, opts))
+
+		reference, err := webp.Decode(bytes.NewReader(out.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, img.Bounds(), reference.Bounds())
+
+		decoded, err := b.Decode(ctx, bytes.NewReader(out.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, img.Bounds(), decoded.Bounds())
+
+		if opts.Lossless {
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 24; x++ {
+					assert.Equal(t, img.NRGBAAt(x, y), color.NRGBAModel.Convert(decoded.At(x, y)))
+				}
+			}
+		}
+	}
+
+	// Empty images are rejected the same way by every backend.
+	var out bytes.Buffer
+	err := b.Encode(ctx, &out, image.NewNRGBA(image.Rect(0, 0, 0, 0)), &Encoder{Quality: 80})
+	assert.ErrorIs(t, err, ErrEmptyImage)
+	assert.Zero(t, out.Len())
+}
+
+func TestProcessBackend(t *testing.T) {
+	testBackend(t, ProcessBackend())
+}
+
+// recordingBackend records the calls made to it.
+type recordingBackend struct {
+	encodes int
+	decodes int
+}
+
+func (b *recordingBackend) Encode(ctx context.Context, w io.Writer, m image.Image, opts *Encoder) error {
+	b.encodes++
+	_, err := w.Write([]byte("webp"))
+	return err
+}
+
+func (b *recordingBackend) Decode(ctx context.Context, r io.Reader) (image.Image, error) {
+	b.decodes++
+	return image.NewNRGBA(image.Rect(0, 0, 1, 1)), nil
+}
+
+func TestSetBackend(t *testing.T) {
+	recorder := &recordingBackend{}
+	SetBackend(recorder)
+	defer SetBackend(nil)
+
+	var b bytes.Buffer
+	err := Encode(&b, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	assert.Nil(t, err)
+	assert.Equal(t, "webp", b.String())
+
+	r, err := (&Encoder{Quality: 150}).EncodeResult(&b, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), r.BytesWritten)
+	assert.Equal(t, uint(100), r.Quality)
+
+	_, err = Decode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, recorder.encodes)
+	assert.Equal(t, 1, recorder.decodes)
+
+	SetBackend(nil)
+	assert.Equal(t, ProcessBackend(), backend())
+}
+
+func TestEncoderBackend(t *testing.T) {
+	recorder, other := &recordingBackend{}, &recordingBackend{}
+	SetBackend(other)
+	defer SetBackend(nil)
+
+	// The backend of the encoder takes precedence over the default backend.
+	var b bytes.Buffer
+	e := &Encoder{Quality: 80, Backend: recorder}
+	assert.Nil(t, e.Encode(&b, image.NewNRGBA(image.Rect(0, 0, 1, 1))))
+	assert.Equal(t, "webp", b.String())
+	assert.Equal(t, 1, recorder.encodes)
+	assert.Zero(t, other.encodes)
+
+	// Encoders without a backend use the default one.
+	assert.Nil(t, (&Encoder{Quality: 80}).Encode(&b, image.NewNRGBA(image.Rect(0, 0, 1, 1))))
+	assert.Equal(t, 1, recorder.encodes)
+	assert.Equal(t, 1, other.encodes)
+}
+
+func TestSetBackendConcurrent(t *testing.T) {
+	defer SetBackend(nil)
+
+	// Switching the default backend doesn't race with encodes using it.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetBackend(ProcessBackend())
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, Encode(io.Discard, image.NewNRGBA(image.Rect(0, 0, 1, 1))))
+		}()
+	}
+	wg.Wait()
+}
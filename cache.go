@@ -0,0 +1,134 @@
+package webpwrap
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+)
+
+// Cache is an in-memory LRU cache of encoded WebP images.
+// Entries are keyed by a hash of the caller-provided key and the encoder options,
+// so the same image encoded with different options is cached separately.
+// When the total size of the cached images exceeds the limit, the least recently used entries are evicted.
+// A Cache is safe for concurrent use.
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64                    // Maximum total size of the cached images
+	size     int64                    // Current total size of the cached images
+	entries  map[string]*list.Element // Cached entries by hashed key
+	order    *list.List               // Cached entries, most recently used first
+
+	// encode encodes an image on a cache miss. It is replaceable for testing.
+	encode func(e *Encoder, ctx context.Context, w io.Writer, m image.Image) error
+}
+
+// cacheEntry is a single cached WebP image.
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+// NewCache creates a new Cache holding at most maxBytes of encoded WebP data.
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+		encode:   (*Encoder).EncodeWithContext,
+	}
+}
+
+// GetOrEncode returns the cached WebP data for key and opts, encoding m on a cache miss.
+// The key must uniquely identify the image content, for example a hash of the source file bytes.
+// If opts is nil, the default settings of Encode are used.
+// Images larger than the cache limit are encoded and returned but not cached.
+// The returned slice is shared with the cache and must not be modified.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - key: The key identifying the image content
+//   - m: The image.Image to encode on a cache miss
+//   - opts: The encoder options, or nil for the defaults
+//
+// Returns:
+//   - []byte: The encoded WebP data
+//   - error: Any error encountered during encoding
+func (c *Cache) GetOrEncode(ctx context.Context, key string, m image.Image, opts *Encoder) ([]byte, error) {
+	if opts == nil {
+		opts = &Encoder{Quality: 75}
+	}
+
+	hashed := cacheKey(key, opts)
+	if data, ok := c.get(hashed); ok {
+		return data, nil
+	}
+
+	var b bytes.Buffer
+	if err := c.encode(opts, ctx, &b, m); err != nil {
+		return nil, err
+	}
+
+	data := b.Bytes()
+	c.add(hashed, data)
+	return data, nil
+}
+
+// Len returns the number of cached images.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// get returns the cached data for the hashed key and marks it as recently used.
+func (c *Cache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).data, true
+}
+
+// add stores data for the hashed key and evicts the least recently used entries above the limit.
+func (c *Cache) add(key string, data []byte) {
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, data: data})
+	c.size += int64(len(data))
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+}
+
+// cacheKey hashes the caller-provided key together with the encoder options.
+func cacheKey(key string, opts *Encoder) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%+v", key, *opts)
+	return hex.EncodeToString(h.Sum(nil))
+}
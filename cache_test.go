@@ -0,0 +1,72 @@
+package webpwrap
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func TestCacheGetOrEncode(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	c := NewCache(1 << 20)
+
+	data, err := c.GetOrEncode(context.Background(), "image", img, nil)
+	assert.Nil(t, err)
+	imgTarget, err := webp.Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), imgTarget.Bounds())
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestCacheHitSkipsEncode(t *testing.T) {
+	calls := 0
+	c := NewCache(1 << 20)
+	c.encode = func(e *Encoder, ctx context.Context, w io.Writer, m image.Image) error {
+		calls++
+		_, err := w.Write([]byte{byte(e.Quality)})
+		return err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+
+	first, err := c.GetOrEncode(context.Background(), "image", img, &Encoder{Quality: 80})
+	assert.Nil(t, err)
+	second, err := c.GetOrEncode(context.Background(), "image", img, &Encoder{Quality: 80})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, first, second)
+
+	_, err = c.GetOrEncode(context.Background(), "image", img, &Encoder{Quality: 50})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestCacheEviction(t *testing.T) {
+	calls := 0
+	c := NewCache(2)
+	c.encode = func(e *Encoder, ctx context.Context, w io.Writer, m image.Image) error {
+		calls++
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	ctx := context.Background()
+
+	c.GetOrEncode(ctx, "a", img, nil)
+	c.GetOrEncode(ctx, "b", img, nil)
+	c.GetOrEncode(ctx, "a", img, nil)
+	c.GetOrEncode(ctx, "c", img, nil)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 2, c.Len())
+
+	// "b" was the least recently used entry and must have been evicted.
+	c.GetOrEncode(ctx, "a", img, nil)
+	assert.Equal(t, 3, calls)
+	c.GetOrEncode(ctx, "b", img, nil)
+	assert.Equal(t, 4, calls)
+}
@@ -0,0 +1,144 @@
+package webpwrap
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+)
+
+// OptionCheck selects how CWebP handles encoding options that don't make sense for the input
+// or for each other, such as alpha options for JPEG input, which has no alpha channel.
+// cwebp silently ignores such options.
+type OptionCheck int
+
+const (
+	// OptionCheckOff passes the options to cwebp without checking them, which is the default.
+	OptionCheckOff OptionCheck = iota
+	// OptionCheckWarn records the problems, which are available through OptionWarnings after Run.
+	OptionCheckWarn
+	// OptionCheckStrict makes Run fail with ErrIncompatibleOptions.
+	OptionCheckStrict
+)
+
+// CheckOptions sets how Run handles options that don't make sense for the input or for each other.
+// The following combinations are detected:
+//   - alpha options (AlphaQuality, AlphaFilter, OptimizeAlpha, Exact or LosslessExact) for inputs
+//     without alpha channel, i.e. JPEG files and images with a gray, Y'CbCr or CMYK color model
+//   - options of lossy compression (FilterStrength, AutoFilter, TargetSize, TargetPSNR, LowMemory,
+//     JPEGLike) combined with lossless compression
+//   - Passes without TargetSize or TargetPSNR
+//
+// The format of file and reader inputs is detected from their header.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) CheckOptions(mode OptionCheck) *CWebP {
+	c.optionCheck = mode
+	return c
+}
+
+// OptionWarnings returns the problems with the options found by the last run with OptionCheckWarn.
+// Returns nil if no problems were found.
+func (c *CWebP) OptionWarnings() []string {
+	return c.optWarnings
+}
+
+// Validate checks the options against the input and each other as described for CheckOptions,
+// regardless of the configured mode, without running cwebp.
+// Returns an error wrapping ErrIncompatibleOptions that lists the problems, or nil if there are none.
+func (c *CWebP) Validate() error {
+	problems, err := c.optionProblems()
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrIncompatibleOptions, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// optionProblems returns the problems with the options described for CheckOptions.
+// For reader input the header is read ahead and kept for cwebp.
+func (c *CWebP) optionProblems() ([]string, error) {
+	var problems []string
+
+	format, alpha, err := c.inputAlpha()
+	if err != nil {
+		return nil, err
+	}
+	if !alpha {
+		for _, option := range []struct {
+			name string
+			set  bool
+		}{
+			{"AlphaQuality", c.alphaQ > -1},
+			{"AlphaFilter", c.alphaFilter != AlphaFilterDefault},
+			{"OptimizeAlpha", c.optimizeAlpha},
+			{"Exact (or LosslessExact)", c.exact},
+		} {
+			if option.set {
+				problems = append(problems, fmt.Sprintf("%s has no effect on %s input without alpha channel", option.name, format))
+			}
+		}
+	}
+
+	if c.lossless || c.effort > -1 || c.nearLossless > -1 {
+		for _, option := range []struct {
+			name string
+			set  bool
+		}{
+			{"FilterStrength", c.filter > -1},
+			{"AutoFilter", c.autoFilter},
+			{"TargetSize", c.targetSize > 0},
+			{"TargetPSNR", c.targetPSNR > 0},
+			{"LowMemory", c.lowMemory},
+			{"JPEGLike", c.jpegLike},
+		} {
+			if option.set {
+				problems = append(problems, fmt.Sprintf("%s has no effect with lossless compression", option.name))
+			}
+		}
+	}
+
+	if c.passes > 0 && c.targetSize == 0 && c.targetPSNR == 0 {
+		problems = append(problems, "Passes has no effect without TargetSize or TargetPSNR")
+	}
+
+	return problems, nil
+}
+
+// inputAlpha returns the format of the input and whether it can have an alpha channel.
+// Inputs of unknown format are assumed to have one.
+func (c *CWebP) inputAlpha() (string, bool, error) {
+	if c.inputImage != nil {
+		switch c.inputImage.ColorModel() {
+		case color.GrayModel, color.Gray16Model, color.YCbCrModel, color.CMYKModel:
+			return "image", false, nil
+		}
+		return "image", true, nil
+	}
+
+	header := make([]byte, sniffSize)
+	var n int
+	switch {
+	case c.input != nil:
+		var err error
+		n, err = io.ReadFull(c.input, header)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return "", false, fmt.Errorf("failed to read input: %w", err)
+		}
+		c.input = io.MultiReader(bytes.NewReader(header[:n]), c.input)
+	case c.inputFile != "":
+		f, err := os.Open(c.inputFile)
+		if err != nil {
+			// The error is reported by cwebp.
+			return "unknown", true, nil
+		}
+		defer f.Close()
+		n, _ = io.ReadFull(f, header)
+	}
+
+	format, _, _ := sniffImage(header[:n])
+	return format, format != "jpeg", nil
+}
@@ -0,0 +1,63 @@
+package webpwrap
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOptionsStrict(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().AlphaQuality(50).CheckOptions(OptionCheckStrict)
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	err := c.Run()
+	assert.ErrorIs(t, err, ErrIncompatibleOptions)
+	assert.Contains(t, err.Error(), "AlphaQuality has no effect on jpeg input")
+	assert.Zero(t, b.Len())
+
+	// The format of streamed input is detected from its header, which is kept for cwebp.
+	f, err := os.Open("source.jpg")
+	assert.Nil(t, err)
+	defer f.Close()
+	c.Input(f)
+	assert.ErrorIs(t, c.Validate(), ErrIncompatibleOptions)
+	c.AlphaQuality(50).Reset().CheckOptions(OptionCheckStrict)
+	assert.Nil(t, c.Run())
+	_, err = DecodeConfig(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+}
+
+func TestCheckOptionsWarn(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().Lossless().FilterStrength(30).Passes(3).CheckOptions(OptionCheckWarn)
+	c.InputImage(image.NewGray(image.Rect(0, 0, 8, 8)))
+	c.AlphaFilter(AlphaFilterBest)
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+	assert.Equal(t, []string{
+		"AlphaFilter has no effect on image input without alpha channel",
+		"FilterStrength has no effect with lossless compression",
+		"Passes has no effect without TargetSize or TargetPSNR",
+	}, c.OptionWarnings())
+
+	// Without checks the options are passed on silently.
+	c.CheckOptions(OptionCheckOff)
+	assert.Nil(t, c.Run())
+	assert.Nil(t, c.OptionWarnings())
+	assert.NotNil(t, c.Validate())
+
+	// Exact and LosslessExact set the same option, so the problem names both.
+	for _, c := range []*CWebP{NewCWebP().Exact(true), NewCWebP().LosslessExact()} {
+		c.InputFile("source.jpg").CheckOptions(OptionCheckStrict)
+		assert.ErrorContains(t, c.Validate(), "Exact (or LosslessExact) has no effect on jpeg input without alpha channel")
+	}
+
+	// Images with alpha channel and consistent options pass.
+	c = NewCWebP().AlphaQuality(50).FilterStrength(30).TargetSize(1000).Passes(3)
+	c.InputImage(image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+	assert.Nil(t, c.Validate())
+}
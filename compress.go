@@ -0,0 +1,89 @@
+package webpwrap
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifies the compression of an input stream.
+type Codec int
+
+const (
+	// CodecNone passes the input through unchanged.
+	CodecNone Codec = iota
+	// CodecGzip decompresses gzip streams.
+	CodecGzip
+	// CodecZstd decompresses Zstandard streams.
+	CodecZstd
+)
+
+// String returns the name of the codec.
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecGzip:
+		return "gzip"
+	case CodecZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Codec(%d)", int(c))
+}
+
+// valid reports whether the codec is supported.
+func (c Codec) valid() bool {
+	return c >= CodecNone && c <= CodecZstd
+}
+
+// decompressingReader decompresses the wrapped reader with the given codec.
+// The decompressor is created on the first read, so the compressed stream
+// isn't touched before the data is consumed.
+type decompressingReader struct {
+	r     io.Reader
+	codec Codec
+	dec   io.Reader
+	close func()
+}
+
+func (d *decompressingReader) Read(p []byte) (int, error) {
+	if d.dec == nil {
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := d.dec.Read(p)
+	if err != nil && d.close != nil {
+		d.close()
+		d.close = nil
+	}
+	return n, err
+}
+
+// open creates the decompressor of the codec.
+func (d *decompressingReader) open() error {
+	switch d.codec {
+	case CodecNone:
+		d.dec = d.r
+	case CodecGzip:
+		dec, err := gzip.NewReader(d.r)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip stream: %w", err)
+		}
+		d.dec, d.close = dec, func() { dec.Close() }
+	case CodecZstd:
+		// With a concurrency of 1 the stream is decoded synchronously, so no goroutines are left
+		// running if the stream is not read to the end.
+		dec, err := zstd.NewReader(d.r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("failed to read zstd stream: %w", err)
+		}
+		d.dec, d.close = dec, dec.Close
+	default:
+		return fmt.Errorf("%w: compression codec %s", ErrUnsupportedOption, d.codec)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package webpwrap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+// compressedPNG returns a PNG of the given size compressed with the codec.
+func compressedPNG(t *testing.T, width, height int, codec Codec) []byte {
+	var raw bytes.Buffer
+	assert.Nil(t, png.Encode(&raw, image.NewNRGBA(image.Rect(0, 0, width, height))))
+
+	var b bytes.Buffer
+	switch codec {
+	case CodecGzip:
+		w := gzip.NewWriter(&b)
+		w.Write(raw.Bytes())
+		assert.Nil(t, w.Close())
+	case CodecZstd:
+		w, err := zstd.NewWriter(&b)
+		assert.Nil(t, err)
+		w.Write(raw.Bytes())
+		assert.Nil(t, w.Close())
+	}
+	return b.Bytes()
+}
+
+func TestEncodeInputCompressed(t *testing.T) {
+	for _, codec := range []Codec{CodecGzip, CodecZstd} {
+		t.Run(codec.String(), func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			c.InputCompressed(bytes.NewReader(compressedPNG(t, 40, 30, codec)), codec)
+			c.Output(&b)
+			err := c.Run()
+			assert.Nil(t, err)
+
+			img, err := webp.Decode(bytes.NewReader(b.Bytes()))
+			assert.Nil(t, err)
+			assert.Equal(t, image.Rect(0, 0, 40, 30), img.Bounds())
+		})
+	}
+}
+
+func TestEncodeInputCompressedUnsupported(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP()
+	c.InputCompressed(bytes.NewReader(nil), Codec(42))
+	c.Output(&b)
+	err := c.Run()
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+}
+
+func TestEncodeInputCompressedCorrupt(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP()
+	c.InputCompressed(bytes.NewReader([]byte("not gzip")), CodecGzip)
+	c.Output(&b)
+	err := c.Run()
+	assert.NotNil(t, err)
+}
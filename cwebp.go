@@ -4,13 +4,32 @@
 package webpwrap
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/belphemur/go-binwrapper"
+	xdraw "golang.org/x/image/draw"
 )
 
 // cropInfo represents the cropping parameters for an image.
@@ -21,18 +40,213 @@ type cropInfo struct {
 	height int // height of the crop area
 }
 
+// canvasInfo represents the canvas the input image is placed on before encoding.
+type canvasInfo struct {
+	width   int // width of the canvas
+	height  int // height of the canvas
+	offsetX int // x-coordinate of the image on the canvas
+	offsetY int // y-coordinate of the image on the canvas
+}
+
+// Preset selects a set of encoding parameters tuned for a type of source material.
+type Preset string
+
+const (
+	// PresetDefault uses the default parameters of cwebp.
+	PresetDefault Preset = "default"
+	// PresetPhoto suits outdoor photographs with natural lighting.
+	PresetPhoto Preset = "photo"
+	// PresetPicture suits indoor photographs such as portraits.
+	PresetPicture Preset = "picture"
+	// PresetDrawing suits hand or line drawings with high contrast details.
+	PresetDrawing Preset = "drawing"
+	// PresetIcon suits small colorful images.
+	PresetIcon Preset = "icon"
+	// PresetText suits text-like images.
+	PresetText Preset = "text"
+)
+
+// presets lists the presets supported by cwebp.
+var presets = []Preset{PresetDefault, PresetPhoto, PresetPicture, PresetDrawing, PresetIcon, PresetText}
+
+// MetadataKind selects a kind of metadata copied from the source image into the WebP output.
+type MetadataKind string
+
+const (
+	// MetadataAll copies all the metadata.
+	MetadataAll MetadataKind = "all"
+	// MetadataNone copies no metadata, which is the default of cwebp.
+	MetadataNone MetadataKind = "none"
+	// MetadataEXIF copies the EXIF metadata.
+	MetadataEXIF MetadataKind = "exif"
+	// MetadataICC copies the ICC color profile.
+	MetadataICC MetadataKind = "icc"
+	// MetadataXMP copies the XMP metadata.
+	MetadataXMP MetadataKind = "xmp"
+)
+
+// metadataKinds lists the kinds of metadata supported by cwebp.
+var metadataKinds = []MetadataKind{MetadataAll, MetadataNone, MetadataEXIF, MetadataICC, MetadataXMP}
+
+// AlphaFilterMethod selects the predictive filtering of the alpha plane while encoding.
+type AlphaFilterMethod int
+
+const (
+	// AlphaFilterDefault uses the default filtering of cwebp, which is AlphaFilterFast.
+	AlphaFilterDefault AlphaFilterMethod = iota
+	// AlphaFilterNone disables the filtering (-alpha_filter none).
+	AlphaFilterNone
+	// AlphaFilterFast picks the filter by a quick estimate (-alpha_filter fast).
+	AlphaFilterFast
+	// AlphaFilterBest tries all filters and keeps the smallest result (-alpha_filter best).
+	AlphaFilterBest
+)
+
+// String returns the name of the alpha filtering method as passed to cwebp.
+func (m AlphaFilterMethod) String() string {
+	switch m {
+	case AlphaFilterDefault:
+		return "default"
+	case AlphaFilterNone:
+		return "none"
+	case AlphaFilterFast:
+		return "fast"
+	case AlphaFilterBest:
+		return "best"
+	}
+	return fmt.Sprintf("AlphaFilterMethod(%d)", int(m))
+}
+
+// ImageHint describes the type of the source image to bias the encoder towards it.
+type ImageHint int
+
+const (
+	// ImageHintDefault passes no hint to cwebp.
+	ImageHintDefault ImageHint = iota
+	// ImageHintPhoto suits outdoor photographs with natural lighting (-hint photo).
+	ImageHintPhoto
+	// ImageHintPicture suits digital pictures such as portraits or indoor shots (-hint picture).
+	ImageHintPicture
+	// ImageHintGraph suits discrete tone images such as graphs, maps or UI screenshots (-hint graph).
+	ImageHintGraph
+)
+
+// String returns the name of the image hint as passed to cwebp.
+func (h ImageHint) String() string {
+	switch h {
+	case ImageHintDefault:
+		return "default"
+	case ImageHintPhoto:
+		return "photo"
+	case ImageHintPicture:
+		return "picture"
+	case ImageHintGraph:
+		return "graph"
+	}
+	return fmt.Sprintf("ImageHint(%d)", int(h))
+}
+
+// EncodeTimings holds the per-stage timings reported by cwebp in verbose mode.
+// Stages that were not reported by the binary are left at zero.
+type EncodeTimings struct {
+	ReadInput   time.Duration // Time to read and decode the input image
+	Encode      time.Duration // Time to encode the picture
+	WriteOutput time.Duration // Time to write the output file
+
+	// Stages holds every reported stage keyed by its description as printed by cwebp
+	// (for example "encode picture"), including stages not covered by the fields above.
+	Stages map[string]time.Duration
+}
+
+// timingPattern matches timing lines such as "Time to encode picture: 0.045s".
+var timingPattern = regexp.MustCompile(`(?mi)^\s*time to ([^:]+?)\s*:\s*([0-9]+(?:[.,][0-9]+)?)\s*(ms|s)?\s*$`)
+
 // CWebP wraps the cwebp command-line tool for compressing images to WebP format.
 // It supports various input formats including PNG, JPEG, TIFF, WebP, and raw Y'CbCr samples.
 // For more information, see: https://developers.google.com/speed/webp/docs/cwebp
 type CWebP struct {
 	*binwrapper.BinWrapper
+	options    []string    // Names of the options passed to NewCWebP
 	inputFile  string      // Path to the input image file
 	inputImage image.Image // Input image as Go image.Image
 	input      io.Reader   // Input as io.Reader
+	inputErr   error       // Error detected while setting the input
 	outputFile string      // Path to the output WebP file
 	output     io.Writer   // Output as io.Writer
+	outputFS   WritableFS  // Filesystem of the output file, nil for the disk
+	pngOutput  io.Writer   // Output of an additional PNG copy of the input image
+	preset     Preset      // Encoding parameter preset, empty if unset
 	quality    int         // Compression quality (0-100)
+	method     int         // Compression method (0-6), -1 if unset
+	targetSize int         // Target output size in bytes, 0 if unset
+	targetPSNR float64     // Target PSNR in dB, 0 if unset
+	passes     int         // Number of entropy analysis passes (1-10), 0 if unset
+	alphaQ     int         // Alpha channel quality (0-100), -1 if unset
+	autoFilter bool        // Adjust the deblocking filter strength automatically
+	filter     int         // Deblocking filter strength (0-100), -1 if unset
+	sns        int         // Spatial noise shaping strength (0-100), -1 if unset
+	sharpYUV   bool        // Use the sharper RGB to YUV conversion
+	lowMemory  bool        // Reduce the memory usage of lossy encoding
+	jpegLike   bool        // Match the output size of a JPEG at the same quality
+	partLimit  int         // Quality degradation to fit the first partition (0-100), -1 if unset
+	lossless   bool        // Use lossless compression
+	exact      bool        // Preserve RGB values under transparent areas
+	effort     int         // Lossless compression effort preset (0-9), -1 if unset
+	threads    int         // Number of threads to use, 0 if unset
+	mt         bool        // Use multi-threading
 	crop       *cropInfo   // Cropping parameters
+	canvas     *canvasInfo // Canvas parameters
+	orient     int         // EXIF orientation of the input image (1-8), 0 if unset
+
+	alphaFilter AlphaFilterMethod                        // Alpha plane filtering method
+	hint        ImageHint                                // Type of the source image
+	preprocess  []func(image.Image) (image.Image, error) // Hooks applied to the input image
+	newHash     func() hash.Hash                         // Hash function of the output, nil if disabled
+	outputHash  string                                   // Hex-encoded hash of the output of the last run
+	optionCheck OptionCheck                              // Handling of options that don't fit the input
+	optWarnings []string                                 // Problems with the options found by the last run
+	minVersion  *versionRequirement                      // Minimum binary version set with RequireMinVersion, nil if unset
+
+	nearLossless  int            // Near-lossless preprocessing level (0-100), -1 if unset
+	aspect        *image.Point   // Aspect ratio of the centered crop set with CropToAspect
+	resize        *image.Point   // Dimensions of the resized image
+	resizeFirst   bool           // Resize the input image before cropping it
+	linearDepth   bool           // Reduce 16-bit input images to 8 bits in linear light
+	optimizeAlpha bool           // Drop the alpha channel of fully opaque input images
+	alphaDropped  bool           // Whether the last run dropped the alpha channel
+	detectGray    bool           // Scan input images for gray pixels
+	grayDetected  bool           // Whether the last run encoded a grayscale image
+	copyMetadata  bool           // Copy the metadata of the input image to the output
+	metadata      []MetadataKind // Kinds of metadata copied to the output, empty if unset
+	placeholder   bool           // Create a placeholder of the input image
+	preview       string         // Data URI of the placeholder created by the last run
+	verbose       bool           // Print additional information including timings
+	timings       *EncodeTimings // Timings of the last verbose run
+	collectStats  bool           // Collect the statistics of the encode operation
+	printPSNR     bool           // Measure the distortion of the output
+	stats         *EncodeStats   // Statistics of the last run with CollectStats
+	skipIfLarger  bool           // Discard outputs larger than the input file
+	skipped       bool           // Whether the last run discarded the output
+	skipUnchanged bool           // Skip encoding if the input and options match the sidecar hash
+	unchanged     bool           // Whether the last run skipped encoding an unchanged input
+	sizeLimit     *sizeLimit     // Maximum output size reached by lowering the quality
+	fallback      *fallback      // Size budget of lossless outputs before falling back to lossy
+	usedLossy     bool           // Whether the last run fell back to lossy compression
+	chosenQuality int            // Quality chosen by the last size limited run
+	summaryInput  bool           // Keep the leading bytes of streamed input for the summary
+	summary       *encodeSummary // Summary of the last successful run
+}
+
+// sizeLimit represents the output size limit set with MaxOutputBytes.
+type sizeLimit struct {
+	maxBytes   int  // maximum size of the output in bytes
+	minQuality uint // lowest quality to try
+}
+
+// fallback represents the lossless size budget set with LosslessOrLossy.
+type fallback struct {
+	maxBytes int  // maximum size of the lossless output in bytes
+	quality  uint // quality of the lossy fallback
 }
 
 // NewCWebP creates a new CWebP instance with the given options.
@@ -40,8 +254,18 @@ type CWebP struct {
 // The quality is set to -1 by default, which means the default cwebp quality will be used.
 func NewCWebP(optionFuncs ...OptionFunc) *CWebP {
 	bin := &CWebP{
-		BinWrapper: createBinWrapper(optionFuncs...),
-		quality:    -1,
+		BinWrapper:    createBinWrapper(optionFuncs...),
+		options:       optionNames(optionFuncs),
+		minVersion:    newVersionRequirement(optionFuncs),
+		quality:       -1,
+		method:        -1,
+		alphaQ:        -1,
+		filter:        -1,
+		sns:           -1,
+		partLimit:     -1,
+		nearLossless:  -1,
+		effort:        -1,
+		chosenQuality: -1,
 	}
 	bin.ExecPath("cwebp")
 	return bin
@@ -53,10 +277,23 @@ func (c *CWebP) Version() (string, error) {
 	return version(c.BinWrapper)
 }
 
+// VersionInfo returns the parsed version of the cwebp binary.
+// Returns the version and any error encountered.
+func (c *CWebP) VersionInfo() (VersionInfo, error) {
+	return versionInfo(c.BinWrapper)
+}
+
+// AppliedOptions returns the names of the options passed to NewCWebP in the order they were applied,
+// such as "WithVendorPath", for debugging the configuration of the instance.
+func (c *CWebP) AppliedOptions() []string {
+	return slices.Clone(c.options)
+}
+
 // InputFile sets the input image file to convert.
 // Any previous calls to Input or InputImage will be ignored.
 // Returns the CWebP instance for method chaining.
 func (c *CWebP) InputFile(file string) *CWebP {
+	c.inputErr = nil
 	c.input = nil
 	c.inputImage = nil
 	c.inputFile = file
@@ -67,6 +304,7 @@ func (c *CWebP) InputFile(file string) *CWebP {
 // Any previous calls to InputFile or InputImage will be ignored.
 // Returns the CWebP instance for method chaining.
 func (c *CWebP) Input(reader io.Reader) *CWebP {
+	c.inputErr = nil
 	c.inputFile = ""
 	c.inputImage = nil
 	c.input = reader
@@ -77,14 +315,111 @@ func (c *CWebP) Input(reader io.Reader) *CWebP {
 // Any previous calls to InputFile or Input will be ignored.
 // Returns the CWebP instance for method chaining.
 func (c *CWebP) InputImage(img image.Image) *CWebP {
+	c.inputErr = nil
 	c.inputFile = ""
 	c.input = nil
 	c.inputImage = img
 	return c
 }
 
+// InputRGBA sets packed, non-premultiplied RGBA samples to convert.
+// The slice must hold exactly width*height*4 bytes in row-major order and is used without copying,
+// so it must not be modified until the run has finished.
+// Any previous calls to InputFile, Input or InputImage will be ignored.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) InputRGBA(pix []byte, width, height int) *CWebP {
+	c.InputImage(nil)
+	if width <= 0 || height <= 0 || len(pix) != width*height*4 {
+		c.inputErr = fmt.Errorf("invalid RGBA input: %d bytes for %dx%d pixels", len(pix), width, height)
+		return c
+	}
+	c.inputImage = &image.NRGBA{
+		Pix:    pix,
+		Stride: width * 4,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+	return c
+}
+
+// InputCompressed sets a compressed reader to convert.
+// The data is decompressed with the given codec while it is piped to cwebp,
+// so the decompressed image is never held in memory as a whole.
+// Run returns an error if the codec is not supported.
+// Any previous calls to InputFile, Input or InputImage will be ignored.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) InputCompressed(reader io.Reader, codec Codec) *CWebP {
+	c.Input(&decompressingReader{r: reader, codec: codec})
+	if !codec.valid() {
+		c.inputErr = fmt.Errorf("%w: compression codec %s", ErrUnsupportedOption, codec)
+	}
+	return c
+}
+
+// dataURITypes lists the media types accepted by InputDataURI.
+var dataURITypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/tiff": true,
+	"image/webp": true,
+}
+
+// InputDataURI sets a data URI such as "data:image/png;base64,..." to convert.
+// The payload may be base64 or percent-encoded. The media type must be one of
+// image/png, image/jpeg, image/tiff or image/webp.
+// Run returns an error if the URI is malformed or has an unsupported media type.
+// Any previous calls to InputFile, Input or InputImage will be ignored.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) InputDataURI(uri string) *CWebP {
+	data, err := parseDataURI(uri)
+	c.Input(bytes.NewReader(data))
+	c.inputErr = err
+	return c
+}
+
+// parseDataURI returns the payload of a data URI with a supported image media type.
+func parseDataURI(uri string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(uri, "data:")
+	if !ok {
+		return nil, errors.New("invalid data URI: missing data: scheme")
+	}
+
+	header, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, errors.New("invalid data URI: missing comma")
+	}
+
+	header, isBase64 := strings.CutSuffix(header, ";base64")
+	if header == "" {
+		return nil, errors.New("invalid data URI: missing media type")
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data URI: %w", err)
+	}
+	if !dataURITypes[mediaType] {
+		return nil, fmt.Errorf("invalid data URI: unsupported media type %q", mediaType)
+	}
+
+	if isBase64 {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data URI: %w", err)
+		}
+		return data, nil
+	}
+
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data URI: %w", err)
+	}
+	return []byte(data), nil
+}
+
 // OutputFile specifies the name of the output WebP file.
 // Any previous call to Output will be ignored.
+// If the output file is the input file set with InputFile, the output is written to a temporary file
+// next to it, which replaces the input file after a successful run.
 // Returns the CWebP instance for method chaining.
 func (c *CWebP) OutputFile(file string) *CWebP {
 	c.output = nil
@@ -101,6 +436,110 @@ func (c *CWebP) Output(writer io.Writer) *CWebP {
 	return c
 }
 
+// Outputs specifies several writers to write the WebP file content to, such as an HTTP response
+// and a cache file. The output of cwebp is streamed to every writer in the given order.
+// If a writer fails, writing stops and Run returns an error wrapping ErrOutputWrite and the
+// writer error, which names the index of the failing writer.
+// Without writers, no output is defined and Run returns an error.
+// Any previous call to OutputFile or Output will be ignored.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Outputs(writers ...io.Writer) *CWebP {
+	switch len(writers) {
+	case 0:
+		return c.Output(nil)
+	case 1:
+		return c.Output(writers[0])
+	}
+	return c.Output(teeWriter(slices.Clone(writers)))
+}
+
+// teeWriter writes to all of its writers and reports the index of a failing one.
+type teeWriter []io.Writer
+
+func (t teeWriter) Write(p []byte) (int, error) {
+	for i, w := range t {
+		n, err := w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return 0, fmt.Errorf("output %d: %w", i, err)
+		}
+	}
+	return len(p), nil
+}
+
+// AlsoWritePNG writes a PNG copy of the input image to the writer in addition to the WebP output.
+// The copy is only written after the WebP output was created successfully.
+// Only images set with InputImage or InputRGBA can be copied; Run returns an error for other inputs.
+// The PNG is encoded once with the default compression level and passed to cwebp as its input,
+// so this costs the PNG compression time on top of the WebP encoding, which otherwise
+// uses an uncompressed intermediate.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) AlsoWritePNG(w io.Writer) *CWebP {
+	c.pngOutput = w
+	return c
+}
+
+// Preprocess registers a hook that transforms the input image before encoding,
+// for example to sharpen or denoise it. Hooks run in registration order, each receiving
+// the image returned by the previous one, after the other in-memory transformations such as
+// Orientation and Canvas. An error returned by a hook aborts the run.
+// Only images set with InputImage or InputRGBA can be preprocessed; Run returns an error for other inputs.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Preprocess(hook func(image.Image) (image.Image, error)) *CWebP {
+	c.preprocess = append(c.preprocess, hook)
+	return c
+}
+
+// WithPlaceholder creates a tiny blurred thumbnail of the input image alongside the WebP output,
+// for showing while the full image loads. The thumbnail is at most 16 pixels on its longer side,
+// follows the crop of the output and is available as a PNG data URI through Placeholder after Run.
+// It is computed from the image in memory without another cwebp run, so only images set with
+// InputImage or InputRGBA are supported; Run returns an error for other inputs.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) WithPlaceholder() *CWebP {
+	c.placeholder = true
+	return c
+}
+
+// Placeholder returns the data URI of the placeholder created by the last successful run
+// with WithPlaceholder, such as "data:image/png;base64,...".
+// Returns an empty string if no placeholder was created.
+func (c *CWebP) Placeholder() string {
+	return c.preview
+}
+
+// Preset selects a set of encoding parameters tuned for the type of source material (-preset).
+// The preset sets the defaults of the other parameters, so options such as Quality or Method
+// refine it regardless of the order of the calls: the preset is always passed to cwebp first.
+// Run returns ErrUnsupportedOption for presets other than the Preset constants.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Preset(preset Preset) *CWebP {
+	c.preset = preset
+	return c
+}
+
+// WithOutputHash hashes the WebP output while it is written, for example to derive
+// cache-busting file names, and makes the hash available through OutputHash after Run.
+// The hash function defaults to SHA-256 if newHash is nil, e.g. md5.New or sha1.New select others.
+// For output files, the output of cwebp is streamed into the file through the hash,
+// so the file doesn't have to be read again.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) WithOutputHash(newHash func() hash.Hash) *CWebP {
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	c.newHash = newHash
+	return c
+}
+
+// OutputHash returns the hex-encoded hash of the output written by the last successful run
+// with WithOutputHash. Returns an empty string if no output was hashed.
+func (c *CWebP) OutputHash() string {
+	return c.outputHash
+}
+
 // Quality specifies the compression factor for RGB channels.
 // The value must be between 0 and 100, where:
 // - A small factor produces a smaller file with lower quality
@@ -115,6 +554,253 @@ func (c *CWebP) Quality(quality uint) *CWebP {
 	return c
 }
 
+// TargetSize makes cwebp search for the compression factor that produces an output of about
+// the given size in bytes (-size). cwebp encodes the image several times to approach the size,
+// which makes encoding slower. The size takes precedence over Quality: when both are set, both are
+// passed and cwebp only uses the quality as the starting point of its search. Unlike MaxOutputBytes,
+// the size is a target rather than a limit, so the output can end up slightly larger.
+// Values of 0 or less remove the target.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) TargetSize(bytes int) *CWebP {
+	c.targetSize = max(bytes, 0)
+	return c
+}
+
+// TargetPSNR makes cwebp search for the compression factor that reaches the given peak signal-to-noise
+// ratio in dB (-psnr), such as 42, instead of using a fixed quality. Like TargetSize, cwebp encodes
+// the image several times for the search and takes the quality set with Quality as starting point.
+// TargetPSNR and TargetSize are mutually exclusive; Run returns an error if both are set.
+// Values of 0 or less remove the target.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) TargetPSNR(psnr float64) *CWebP {
+	c.targetPSNR = max(psnr, 0)
+	return c
+}
+
+// Passes sets the number of entropy analysis passes (-pass) cwebp uses to reach the target
+// set with TargetSize or TargetPSNR. More passes hit the target more accurately at the cost of
+// encoding time. Without a target, cwebp ignores the setting. The number ranges from 1 to 10;
+// higher values are clamped to 10 and 0 restores the default.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Passes(n uint) *CWebP {
+	c.passes = int(min(n, 10))
+	return c
+}
+
+// Method sets the compression method (-m), which trades encoding speed for output size.
+// The method ranges from 0 (fastest) to 6 (slowest, smallest output); higher values are clamped to 6.
+// Without a call, cwebp uses its default method 4.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Method(method uint) *CWebP {
+	if method > 6 {
+		method = 6
+	}
+	c.method = int(method)
+	return c
+}
+
+// AutoFilter enables or disables the automatic adjustment of the deblocking filter strength (-af),
+// which often improves the quality at low bitrates at the cost of encoding time.
+// The automatic adjustment takes precedence over an explicit filter strength.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) AutoFilter(enabled bool) *CWebP {
+	c.autoFilter = enabled
+	return c
+}
+
+// SharpYUV enables the sharper and more accurate RGB to YUV conversion of lossy compression (-sharp_yuv),
+// which avoids the chroma bleeding of the default conversion around saturated colors,
+// such as red details on dark backgrounds. The conversion is considerably slower than the default one.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) SharpYUV(enabled bool) *CWebP {
+	c.sharpYUV = enabled
+	return c
+}
+
+// LowMemory reduces the memory usage of cwebp (-low_memory) at the cost of a slightly slower encoding,
+// which helps to encode large images in memory-constrained environments.
+// It only affects lossy compression; lossless encoding uses as much memory as before.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) LowMemory(enabled bool) *CWebP {
+	c.lowMemory = enabled
+	return c
+}
+
+// JPEGLike adjusts the lossy compression to produce outputs of about the size of a JPEG
+// compressed at the same quality (-jpeg_like), which eases migrating from JPEG to WebP.
+// It refines the quality set with Quality rather than replacing it, so both are passed to cwebp.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) JPEGLike(enabled bool) *CWebP {
+	c.jpegLike = enabled
+	return c
+}
+
+// PartitionLimit allows cwebp to degrade the quality of the most complex blocks (-partition_limit),
+// so the first partition of a lossy output, which holds the modes of all blocks, fits its limit of 512 KiB.
+// This is required to encode very large images, which fail otherwise. The limit ranges from 0
+// (no degradation) to 100 (full degradation); higher values are clamped to 100.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) PartitionLimit(limit uint) *CWebP {
+	c.partLimit = int(min(limit, 100))
+	return c
+}
+
+// FilterStrength sets the strength of the in-loop deblocking filter (-f) of lossy compression.
+// The strength ranges from 0 (no filtering) to 100 (strongest filtering); higher values are clamped
+// to 100. Unlike not calling it, a strength of 0 is passed to cwebp and disables the filtering.
+// AutoFilter takes precedence over the strength if both are set.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) FilterStrength(strength uint) *CWebP {
+	c.filter = int(min(strength, 100))
+	return c
+}
+
+// SpatialNoiseShaping sets the strength of the spatial noise shaping (-sns) of lossy compression,
+// which moves bits from flat areas to busy areas to preserve their detail. The strength ranges
+// from 0 (off) to 100 (strongest); higher values are clamped to 100.
+// Without a call, cwebp uses the strength of the preset.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) SpatialNoiseShaping(strength uint) *CWebP {
+	c.sns = int(min(strength, 100))
+	return c
+}
+
+// AlphaQuality sets the compression factor for the alpha channel (-alpha_q), independently of Quality.
+// The quality ranges from 0 (smallest size) to 100 (lossless alpha); higher values are clamped to 100.
+// cwebp ignores the setting for images without alpha channel.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) AlphaQuality(quality uint) *CWebP {
+	if quality > 100 {
+		quality = 100
+	}
+	c.alphaQ = int(quality)
+	return c
+}
+
+// AlphaFilter sets the predictive filtering method of the alpha plane (-alpha_filter).
+// AlphaFilterBest compresses the alpha plane best at the cost of encoding time.
+// Values other than the AlphaFilterMethod constants are ignored and keep the current method.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) AlphaFilter(method AlphaFilterMethod) *CWebP {
+	if method >= AlphaFilterDefault && method <= AlphaFilterBest {
+		c.alphaFilter = method
+	}
+	return c
+}
+
+// Hint describes the type of the source image (-hint), which biases the encoder towards it;
+// for example ImageHintGraph helps with UI screenshots. Unlike Preset, which replaces the defaults
+// of the other parameters, the hint only guides the encoder, so both can be used together.
+// The setter can't return an error without breaking the chain, so unknown hints are rejected
+// by Run, which returns ErrUnsupportedOption for hints other than the ImageHint constants.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Hint(h ImageHint) *CWebP {
+	c.hint = h
+	return c
+}
+
+// Lossless enables lossless compression of the image (-lossless).
+// In lossless mode the quality factor controls the compression effort instead of the visual quality.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Lossless() *CWebP {
+	c.lossless = true
+	return c
+}
+
+// LosslessExact enables lossless compression that preserves the image exactly (-lossless -exact).
+// By default cwebp modifies the RGB values of fully transparent pixels to improve the compression;
+// with exact mode, decoding the output yields every pixel of the input unchanged, including
+// the color values under fully transparent alpha. Exact preservation requires the input to be
+// non-premultiplied, such as an *image.NRGBA set with InputImage or a PNG file.
+// It is a shorthand for Lossless followed by Exact(true), which set the same options.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) LosslessExact() *CWebP {
+	c.lossless = true
+	c.exact = true
+	return c
+}
+
+// Exact preserves the RGB values of fully transparent pixels (-exact), which cwebp otherwise
+// modifies to improve the compression. This avoids fringes when the output is later composited
+// over different backgrounds. Unlike LosslessExact it doesn't enable lossless compression:
+// with lossy compression, the color values under transparent areas are kept as far as the lossy
+// encoding allows, and encoding them usually increases the size of the output, including that of
+// its lossy alpha plane. Exact(false) disables it again, also after LosslessExact.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Exact(enabled bool) *CWebP {
+	c.exact = enabled
+	return c
+}
+
+// NearLossless enables lossless compression with near-lossless preprocessing (-near_lossless).
+// The preprocessing adjusts pixel values slightly to compress better, which suits images
+// with smooth gradients. The level ranges from 0 (strongest preprocessing) to 100 (no preprocessing);
+// higher values are clamped to 100. It can be combined with Lossless and LosslessEffort.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) NearLossless(level uint) *CWebP {
+	if level > 100 {
+		level = 100
+	}
+	c.nearLossless = int(level)
+	return c
+}
+
+// ScreenshotMode tunes the encoding for screenshots and other images with text, keeping glyph edges crisp
+// where lossy compression blurs them and rings around them. It sets the following options:
+//
+//	-preset text       Preset(PresetText)
+//	-hint graph        Hint(ImageHintGraph)
+//	-lossless          Lossless()
+//	-near_lossless 80  NearLossless(80)
+//
+// The near-lossless preprocessing only adjusts smooth areas slightly, which keeps text intact
+// while compressing gradients and photos embedded in the screenshot better.
+// Options set after ScreenshotMode override the ones above.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) ScreenshotMode() *CWebP {
+	return c.Preset(PresetText).Hint(ImageHintGraph).Lossless().NearLossless(80)
+}
+
+// LosslessEffort enables lossless compression with the given effort level (-z).
+// In lossless mode cwebp reuses -q and -m to control the effort of the compression
+// rather than the visual quality. The effort level selects both at once, from 0 (fastest)
+// to 9 (slowest, smallest output); higher values are clamped to 9. The levels map to
+// the following method (-m) and quality (-q) settings:
+//
+//	level:    0  1   2   3   4   5   6   7   8   9
+//	method:   0  1   2   3   3   4   4   4   5   6
+//	quality:  0 20  25  30  50  50  75  90  90 100
+//
+// The effort level takes precedence over the quality set with Quality.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) LosslessEffort(level uint) *CWebP {
+	if level > 9 {
+		level = 9
+	}
+	c.effort = int(level)
+	return c
+}
+
+// MultiThreading enables or disables multi-threaded encoding (-mt), which speeds up encoding
+// large images on machines with several cores. Multi-threading is disabled by default.
+// Threads with a count greater than 1 enables it as well, regardless of this setting.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) MultiThreading(enabled bool) *CWebP {
+	c.mt = enabled
+	return c
+}
+
+// Threads bounds the number of threads cwebp uses for encoding.
+// cwebp is single-threaded by default; with n greater than 1 multi-threading is enabled (-mt).
+// libwebp has no flag for the number of threads: its multi-threaded encoder uses a fixed
+// small number of worker threads, so -mt is the only control.
+// Values below 1 restore the default.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Threads(n int) *CWebP {
+	c.threads = max(n, 0)
+	return c
+}
+
 // Crop sets the cropping parameters for the source image.
 // The cropping area must be fully contained within the source rectangle.
 // Parameters:
@@ -125,94 +811,1367 @@ func (c *CWebP) Quality(quality uint) *CWebP {
 //
 // Returns the CWebP instance for method chaining.
 func (c *CWebP) Crop(x, y, width, height int) *CWebP {
+	c.aspect = nil
 	c.crop = &cropInfo{x, y, width, height}
 	return c
 }
 
-// Run executes the cwebp command with the specified parameters.
-// Returns an error if the command fails or if input/output is not properly configured.
-func (c *CWebP) Run() error {
-	return c.RunWithContext(context.Background())
+// Resize scales the source image to width x height pixels before encoding (-resize).
+// If one of the dimensions is 0, it is computed from the other one to preserve the aspect ratio;
+// if both are 0, the image isn't resized. Like cwebp, the image is resized after cropping it,
+// so the crop area is given in the coordinates of the source; see ResizeThenCrop for the opposite.
+// A negative dimension is treated like 0; Run returns an error if both dimensions are negative.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Resize(width, height int) *CWebP {
+	c.resize = &image.Point{width, height}
+	return c
 }
 
-// RunWithContext executes the cwebp command with the specified parameters and context.
-// The context can be used to cancel the operation.
-// Returns an error if the command fails or if input/output is not properly configured.
-func (c *CWebP) RunWithContext(ctx context.Context) error {
-	defer c.BinWrapper.Reset()
+// GammaAwareDownsample reduces input images with 16 bits per channel (*image.NRGBA64, *image.RGBA64
+// and *image.Gray16) to the 8 bits per channel of WebP in linear light, instead of letting cwebp
+// truncate the samples. The quantization error is diffused to the neighboring pixels, which avoids
+// the banding of smooth gradients caused by truncation. The conversion takes noticeably longer than
+// the encoding of small images, as it transforms every sample to linear light and back.
+// It only applies to images set with InputImage; images with 8 bits per channel are left unchanged.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) GammaAwareDownsample(enabled bool) *CWebP {
+	c.linearDepth = enabled
+	return c
+}
 
-	if c.quality > -1 {
-		c.Arg("-q", fmt.Sprintf("%d", c.quality))
-	}
+// ResizeThenCrop resizes the image before cropping it, so the crop area set with Crop or CropToAspect
+// is given in the coordinates of the resized image. cwebp always crops first, so the image is
+// resized in memory before encoding and only images set with InputImage or InputRGBA are supported;
+// Run returns an error for other inputs if both a resize and a crop are set.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) ResizeThenCrop() *CWebP {
+	c.resizeFirst = true
+	return c
+}
 
-	if c.crop != nil {
-		c.Arg("-crop", fmt.Sprintf("%d", c.crop.x), fmt.Sprintf("%d", c.crop.y),
-			fmt.Sprintf("%d", c.crop.width), fmt.Sprintf("%d", c.crop.height))
-	}
+// CropToAspect crops the source image to the largest centered area with the aspect ratio width:height.
+// The crop area is computed with AspectCrop from the input dimensions, which are read from the
+// header of the input file or reader, so the input must be a PNG, JPEG, GIF or WebP image or an image
+// set with InputImage. Any previous call to Crop will be ignored.
+// Run returns an error if the aspect ratio is not positive or the input dimensions can't be determined.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) CropToAspect(width, height int) *CWebP {
+	c.crop = nil
+	c.aspect = &image.Point{width, height}
+	return c
+}
 
-	output, err := c.getOutput()
-	if err != nil {
-		return fmt.Errorf("failed to get output: %w", err)
+// AspectCrop returns the largest rectangle with the aspect ratio aspectWidth:aspectHeight
+// centered in an image of the given dimensions.
+// Returns an empty rectangle if any of the values is not positive.
+func AspectCrop(width, height, aspectWidth, aspectHeight int) image.Rectangle {
+	if width <= 0 || height <= 0 || aspectWidth <= 0 || aspectHeight <= 0 {
+		return image.Rectangle{}
 	}
 
-	c.Arg("-o", output)
-
-	if err := c.setInput(); err != nil {
-		return fmt.Errorf("failed to set input: %w", err)
+	cropWidth, cropHeight := width, height
+	if int64(width)*int64(aspectHeight) > int64(height)*int64(aspectWidth) {
+		cropWidth = int(int64(height) * int64(aspectWidth) / int64(aspectHeight))
+	} else {
+		cropHeight = int(int64(width) * int64(aspectHeight) / int64(aspectWidth))
 	}
+	cropWidth, cropHeight = max(cropWidth, 1), max(cropHeight, 1)
 
-	if c.output != nil {
-		c.SetStdOut(c.output)
-	}
+	x, y := (width-cropWidth)/2, (height-cropHeight)/2
+	return image.Rect(x, y, x+cropWidth, y+cropHeight)
+}
 
-	// Create a channel to handle context cancellation
-	done := make(chan struct{})
-	go func() {
-		<-ctx.Done()
-		c.BinWrapper.Kill()
-		close(done)
-	}()
+// Orientation sets the EXIF orientation of the image set with InputImage, from 1 to 8.
+// The image is rotated and flipped accordingly before encoding, so the output is upright:
+// for example, an image with orientation 6 is rotated by 90 degrees clockwise.
+// This is meant for callers who read the orientation from the metadata of the source image themselves.
+// Orientation 1 leaves the image unchanged. The orientation is applied before Canvas places the image.
+// Run returns an error for other values or if the input is not an image set with InputImage.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Orientation(o int) *CWebP {
+	c.orient = o
+	return c
+}
 
-	err = c.BinWrapper.Run()
-	if err != nil {
-		select {
-		case <-done:
-			return fmt.Errorf("operation cancelled: %w", ctx.Err())
-		default:
-			return fmt.Errorf("cwebp command failed: %w. stderr: %s", err, c.StdErr())
-		}
-	}
+// OptimizeAlpha enables detection of fully opaque input images.
+// When the image set with InputImage has no pixel with an alpha value below 255,
+// the alpha channel is discarded (-noalpha) to avoid encoding a useless alpha plane.
+// Inputs set with InputFile or Input are passed through unchanged.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) OptimizeAlpha() *CWebP {
+	c.optimizeAlpha = true
+	return c
+}
 
-	return nil
+// AlphaDropped reports whether the last run discarded the alpha channel
+// because the input image was fully opaque. See OptimizeAlpha.
+func (c *CWebP) AlphaDropped() bool {
+	return c.alphaDropped
 }
 
-// Reset restores all parameters to their default values.
+// DetectGrayscale enables detection of grayscale content in images of any type.
+// Images of type *image.Gray and *image.Gray16 are always recognized as grayscale,
+// other images set with InputImage are scanned for opaque pixels with equal red, green and blue values.
+// Grayscale images are passed to cwebp as 8-bit grayscale instead of RGB data, which shrinks the
+// intermediate image to a third. The WebP output itself doesn't shrink, as the format has no
+// separate grayscale mode and already encodes gray pixels with flat chroma.
 // Returns the CWebP instance for method chaining.
-func (c *CWebP) Reset() *CWebP {
-	c.crop = nil
-	c.quality = -1
+func (c *CWebP) DetectGrayscale() *CWebP {
+	c.detectGray = true
 	return c
 }
 
-// setInput configures the input source for the cwebp command.
-// Returns an error if no input source is defined.
-func (c *CWebP) setInput() error {
-	if c.input != nil {
+// GrayscaleDetected reports whether the last run encoded the input image as grayscale.
+// See DetectGrayscale.
+func (c *CWebP) GrayscaleDetected() bool {
+	return c.grayDetected
+}
+
+// CopyMetadataFromInput copies the ICC profile, EXIF and XMP metadata of the source image
+// into the WebP output (-metadata all).
+// Metadata is read by cwebp from the source container, so this works for JPEG and PNG data
+// set with InputFile or Input. Images set with InputImage or InputRGBA carry no metadata,
+// so the option has no effect for them. Sources lacking metadata are encoded normally.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) CopyMetadataFromInput() *CWebP {
+	c.copyMetadata = true
+	return c
+}
+
+// Metadata selects the kinds of metadata copied from the source image into the WebP output
+// (-metadata), e.g. Metadata(MetadataICC) keeps the color profile for print workflows.
+// Duplicate kinds are dropped and the others are passed to cwebp comma-separated in the given order.
+// The kinds take precedence over CopyMetadataFromInput, which is the same as Metadata(MetadataAll).
+// Like CopyMetadataFromInput, this only works for sources set with InputFile or Input whose
+// container cwebp reads metadata from, such as JPEG and PNG; images set with InputImage or
+// InputRGBA carry no metadata, so the option has no effect for them.
+// Run returns ErrUnsupportedOption for kinds other than the MetadataKind constants.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Metadata(kinds ...MetadataKind) *CWebP {
+	c.metadata = nil
+	for _, kind := range kinds {
+		if !slices.Contains(c.metadata, kind) {
+			c.metadata = append(c.metadata, kind)
+		}
+	}
+	return c
+}
+
+// Verbose enables verbose output of cwebp (-v), which includes per-stage timings.
+// After a successful run the timings are available through Timings.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Verbose() *CWebP {
+	c.verbose = true
+	return c
+}
+
+// Timings returns the per-stage timings reported by the last successful verbose run.
+// Returns nil if Verbose was not enabled for the last run.
+func (c *CWebP) Timings() *EncodeTimings {
+	return c.timings
+}
+
+// SkipIfLarger discards the WebP output if it is larger than the input file.
+// Already optimized or very small images can grow when converted to WebP, in which case
+// keeping the original is preferable. The output file is removed after encoding and
+// Skipped reports true, so the caller can keep using the input file instead.
+// This requires the input to be set with InputFile and the output with OutputFile;
+// Run returns an error for other inputs and outputs.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) SkipIfLarger() *CWebP {
+	c.skipIfLarger = true
+	return c
+}
+
+// SkipIfUnchanged skips encoding if the output file was created from the same input with the same options.
+// The input and the cwebp arguments are hashed with SHA-256 and the hash is stored in a sidecar file
+// next to the output, named like the output file with the suffix ".sha256". When the hash matches
+// the sidecar and the output file exists, Run returns without encoding and Unchanged reports true.
+// Unlike a modification time check, touching the input without changing its content doesn't trigger
+// an encode. Streamed input set with Input is buffered to be hashed. The version of cwebp is not part
+// of the hash, so remove the sidecar files to encode again after an upgrade.
+// This requires the output to be set with OutputFile and can't be combined with MaxOutputBytes
+// or LosslessOrLossy; Run returns an error otherwise.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) SkipIfUnchanged() *CWebP {
+	c.skipUnchanged = true
+	return c
+}
+
+// Unchanged reports whether the last run skipped encoding because the input and options were unchanged.
+// See SkipIfUnchanged.
+func (c *CWebP) Unchanged() bool {
+	return c.unchanged
+}
+
+// Skipped reports whether the last run discarded the output because it was larger than the input.
+// See SkipIfLarger.
+func (c *CWebP) Skipped() bool {
+	return c.skipped
+}
+
+// MaxOutputBytes limits the size of the WebP output by lowering the quality as far as needed.
+// The image is encoded repeatedly in memory, binary searching for the highest quality between
+// minQuality and the quality set with Quality (75 by default) whose output fits into maxBytes.
+// If the output at minQuality still exceeds maxBytes, Run returns ErrCannotMeetSize.
+// Unlike the -size option of cwebp, the limit is a hard ceiling and the quality never drops below minQuality.
+// Each step of the search is a complete encode, so this takes several times longer than a single run.
+// Streamed input set with Input is buffered in memory to be encoded repeatedly.
+// The chosen quality is available through ChosenQuality.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) MaxOutputBytes(maxBytes int, minQuality uint) *CWebP {
+	if minQuality > 100 {
+		minQuality = 100
+	}
+	c.fallback = nil
+	c.sizeLimit = &sizeLimit{maxBytes, minQuality}
+	return c
+}
+
+// LosslessOrLossy encodes losslessly and falls back to lossy compression at fallbackQuality
+// if the lossless output is larger than maxBytes. This suits pipelines with graphics and photos:
+// graphics stay lossless while photos, whose lossless output is large, are compressed lossy.
+// The lossy output is used even if it exceeds maxBytes as well; combine with a lower fallback
+// quality to make that unlikely. Options for lossless compression such as LosslessEffort only
+// apply to the lossless attempt. Both attempts are encoded in memory; streamed input set with
+// Input is buffered to be encoded twice, and images set with InputImage are prepared for each attempt
+// without being decoded again. Whether the fallback was used is available through UsedLossyFallback.
+// Any previous call to MaxOutputBytes will be ignored.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) LosslessOrLossy(maxBytes int, fallbackQuality uint) *CWebP {
+	c.sizeLimit = nil
+	c.fallback = &fallback{maxBytes, min(fallbackQuality, 100)}
+	return c
+}
+
+// UsedLossyFallback reports whether the last successful run with LosslessOrLossy
+// fell back to lossy compression.
+func (c *CWebP) UsedLossyFallback() bool {
+	return c.usedLossy
+}
+
+// ChosenQuality returns the quality chosen by the last successful run with MaxOutputBytes.
+// Returns -1 if MaxOutputBytes was not set for the last run.
+func (c *CWebP) ChosenQuality() int {
+	return c.chosenQuality
+}
+
+// Canvas places the input image on a transparent canvas of the given size before encoding.
+// The image is drawn with its top-left corner at (offsetX, offsetY) and must fit entirely within the canvas.
+// Only images set with InputImage can be placed on a canvas; Run returns an error for other inputs.
+// Parameters:
+//   - width: width of the canvas
+//   - height: height of the canvas
+//   - offsetX: x-coordinate of the image on the canvas
+//   - offsetY: y-coordinate of the image on the canvas
+//
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Canvas(width, height int, offsetX, offsetY int) *CWebP {
+	c.canvas = &canvasInfo{width, height, offsetX, offsetY}
+	return c
+}
+
+// Run executes the cwebp command with the specified parameters.
+// Returns an error if the command fails or if input/output is not properly configured.
+func (c *CWebP) Run() error {
+	return c.RunWithContext(context.Background())
+}
+
+// RunWithContext executes the cwebp command with the specified parameters and context.
+// The context can be used to cancel the operation.
+// Returns an error if the command fails or if input/output is not properly configured.
+func (c *CWebP) RunWithContext(ctx context.Context) error {
+	if err := c.minVersion.check(c.BinWrapper, "cwebp"); err != nil {
+		return err
+	}
+
+	if c.outputFS != nil && c.outputFile != "" {
+		return c.runToFS(ctx)
+	}
+	if c.sizeLimit != nil {
+		return c.runWithSizeLimit(ctx)
+	}
+	if c.fallback != nil {
+		return c.runWithFallback(ctx)
+	}
+
+	defer c.BinWrapper.Reset()
+
+	c.alphaDropped = false
+	c.grayDetected = false
+	c.timings = nil
+	c.stats = nil
+	c.skipped = false
+	c.unchanged = false
+	c.outputHash = ""
+	c.optWarnings = nil
+	c.summary = nil
+	c.preview = ""
+
+	if c.skipIfLarger && (c.inputFile == "" || c.outputFile == "") {
+		return errors.New("SkipIfLarger requires an input file and an output file")
+	}
+
+	if c.optionCheck != OptionCheckOff {
+		problems, err := c.optionProblems()
+		if err != nil {
+			return err
+		}
+		if c.optionCheck == OptionCheckStrict && len(problems) > 0 {
+			return fmt.Errorf("%w: %s", ErrIncompatibleOptions, strings.Join(problems, "; "))
+		}
+		c.optWarnings = problems
+	}
+
+	var shortStats bool
+	if c.collectStats {
+		// Query the version before any argument is added, as it resets the binary wrapper.
+		v, err := c.VersionInfo()
+		if err != nil {
+			return fmt.Errorf("failed to get cwebp version: %w", err)
+		}
+		shortStats = v.AtLeast(shortStatsSince.Major, shortStatsSince.Minor, shortStatsSince.Patch)
+	}
+
+	img, err := c.prepareImage()
+	if err != nil {
+		return fmt.Errorf("failed to prepare input image: %w", err)
+	}
+
+	if img != nil {
+		if gray, ok := grayscale(img, c.detectGray); ok {
+			img = gray
+			c.grayDetected = true
+		}
+	}
+
+	// The preset overwrites the other parameters, so it has to precede them.
+	if c.preset != "" {
+		if !slices.Contains(presets, c.preset) {
+			return fmt.Errorf("%w: unknown preset %q", ErrUnsupportedOption, c.preset)
+		}
+		c.Arg("-preset", string(c.preset))
+	}
+
+	if c.quality > -1 {
+		c.Arg("-q", fmt.Sprintf("%d", c.quality))
+	}
+
+	if c.targetSize > 0 && c.targetPSNR > 0 {
+		return errors.New("TargetSize and TargetPSNR are mutually exclusive")
+	}
+
+	if c.targetSize > 0 {
+		c.Arg("-size", strconv.Itoa(c.targetSize))
+	}
+
+	if c.targetPSNR > 0 {
+		c.Arg("-psnr", strconv.FormatFloat(c.targetPSNR, 'f', 2, 64))
+	}
+
+	if c.passes > 0 {
+		c.Arg("-pass", strconv.Itoa(c.passes))
+	}
+
+	if c.method > -1 {
+		c.Arg("-m", strconv.Itoa(c.method))
+	}
+
+	if c.filter > -1 {
+		c.Arg("-f", strconv.Itoa(c.filter))
+	}
+
+	if c.autoFilter {
+		c.Arg("-af")
+	}
+
+	if c.sns > -1 {
+		c.Arg("-sns", strconv.Itoa(c.sns))
+	}
+
+	if c.sharpYUV {
+		c.Arg("-sharp_yuv")
+	}
+
+	if c.lowMemory {
+		c.Arg("-low_memory")
+	}
+
+	if c.jpegLike {
+		c.Arg("-jpeg_like")
+	}
+
+	if c.partLimit > -1 {
+		c.Arg("-partition_limit", strconv.Itoa(c.partLimit))
+	}
+
+	if c.alphaQ > -1 {
+		c.Arg("-alpha_q", strconv.Itoa(c.alphaQ))
+	}
+
+	if c.alphaFilter != AlphaFilterDefault {
+		c.Arg("-alpha_filter", c.alphaFilter.String())
+	}
+
+	if c.hint != ImageHintDefault {
+		if c.hint < ImageHintPhoto || c.hint > ImageHintGraph {
+			return fmt.Errorf("%w: unknown image hint %s", ErrUnsupportedOption, c.hint)
+		}
+		c.Arg("-hint", c.hint.String())
+	}
+
+	if c.lossless {
+		c.Arg("-lossless")
+	}
+
+	if c.exact {
+		c.Arg("-exact")
+	}
+
+	if c.effort > -1 {
+		c.Arg("-z", strconv.Itoa(c.effort))
+	}
+
+	if c.nearLossless > -1 {
+		c.Arg("-near_lossless", strconv.Itoa(c.nearLossless))
+	}
+
+	if c.mt || c.threads > 1 {
+		c.Arg("-mt")
+	}
+
+	crop := c.crop
+	if c.aspect != nil {
+		if crop, err = c.aspectCrop(img); err != nil {
+			return err
+		}
+	}
+
+	var placeholder string
+	if c.placeholder {
+		if placeholder, err = placeholderDataURI(img, crop); err != nil {
+			return err
+		}
+	}
+
+	if crop != nil {
+		c.Arg("-crop", fmt.Sprintf("%d", crop.x), fmt.Sprintf("%d", crop.y),
+			fmt.Sprintf("%d", crop.width), fmt.Sprintf("%d", crop.height))
+	}
+
+	if c.resize != nil && !c.resizeInMemory() {
+		size, err := resizeDimensions(*c.resize)
+		if err != nil {
+			return err
+		}
+		if size.X > 0 || size.Y > 0 {
+			c.Arg("-resize", strconv.Itoa(size.X), strconv.Itoa(size.Y))
+		}
+	}
+
+	if len(c.metadata) > 0 {
+		kinds := make([]string, len(c.metadata))
+		for i, kind := range c.metadata {
+			if !slices.Contains(metadataKinds, kind) {
+				return fmt.Errorf("%w: unknown metadata kind %q", ErrUnsupportedOption, kind)
+			}
+			kinds[i] = string(kind)
+		}
+		if img == nil {
+			c.Arg("-metadata", strings.Join(kinds, ","))
+		}
+	} else if c.copyMetadata && img == nil {
+		c.Arg("-metadata", string(MetadataAll))
+	}
+
+	if c.verbose {
+		c.Arg("-v")
+	}
+
+	if shortStats {
+		c.Arg("-short")
+	}
+
+	if c.printPSNR {
+		c.Arg("-print_psnr")
+	}
+
+	if c.optimizeAlpha && img != nil && isOpaque(img) {
+		c.Arg("-noalpha")
+		c.alphaDropped = true
+	}
+
+	var digest string
+	if c.skipUnchanged {
+		if c.output != nil || c.outputFile == "" {
+			return errors.New("SkipIfUnchanged requires an output file")
+		}
+		if digest, err = c.inputHash(img); err != nil {
+			return err
+		}
+		if c.unchanged = isUnchanged(c.outputFile, digest); c.unchanged {
+			return nil
+		}
+	}
+
+	output, err := c.getOutput()
+	if err != nil {
+		return fmt.Errorf("failed to get output: %w", err)
+	}
+
+	// Writing to the input file directly could truncate it before cwebp read it.
+	inPlace := c.inPlace()
+	if inPlace {
+		if output, err = tempOutputFile(c.outputFile); err != nil {
+			return err
+		}
+		defer os.Remove(output)
+	}
+
+	// Hashed output files are written through the hash instead of by cwebp.
+	var h hash.Hash
+	var hashedFile *os.File
+	if c.newHash != nil {
+		h = c.newHash()
+	}
+	if h != nil && c.output == nil {
+		if hashedFile, err = os.Create(output); err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer hashedFile.Close()
+		c.Arg("-o", "-")
+	} else {
+		c.Arg("-o", output)
+	}
+
+	var pngCopy []byte
+	if c.pngOutput != nil {
+		if pngCopy, err = encodePNGCopy(img); err != nil {
+			return err
+		}
+	}
+
+	stdin, err := c.setInput(img, pngCopy)
+	if err != nil {
+		return fmt.Errorf("failed to set input: %w", err)
+	}
+
+	// Keep the start of streamed input to describe it in the summary.
+	var prefix *prefixBuffer
+	if c.input != nil && c.summaryInput {
+		prefix = &prefixBuffer{max: sniffSize}
+		stdin = io.TeeReader(stdin, prefix)
+	}
+
+	var ow *outputWriter
+	switch {
+	case c.output != nil && h != nil:
+		ow = &outputWriter{w: io.MultiWriter(c.output, h)}
+	case c.output != nil:
+		ow = &outputWriter{w: c.output}
+	case hashedFile != nil:
+		ow = &outputWriter{w: io.MultiWriter(hashedFile, h)}
+	}
+
+	start := time.Now()
+	_, stderr, err := runBinary(ctx, c.BinWrapper, "cwebp", stdin, ow)
+	if err != nil {
+		if hashedFile != nil {
+			hashedFile.Close()
+			os.Remove(output)
+		}
+		return err
+	}
+	if hashedFile != nil {
+		if err := hashedFile.Close(); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+	}
+	c.summary = c.newSummary(img, prefix, ow, output, stderr, time.Since(start))
+	c.preview = placeholder
+
+	if c.verbose {
+		c.timings = parseTimings(stderr)
+	}
+	if c.collectStats || c.printPSNR {
+		c.stats = parseStats(stderr)
+	}
+
+	if c.skipIfLarger {
+		if err := c.discardIfLarger(output); err != nil {
+			return err
+		}
+	}
+
+	if inPlace && !c.skipped {
+		if err := replaceFile(output, c.outputFile); err != nil {
+			return err
+		}
+	}
+
+	if c.skipUnchanged {
+		if err := writeSidecarHash(c.outputFile, digest, c.skipped); err != nil {
+			return err
+		}
+	}
+
+	if h != nil && !c.skipped {
+		c.outputHash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if c.pngOutput != nil {
+		if _, err := c.pngOutput.Write(pngCopy); err != nil {
+			return fmt.Errorf("%w: %w", ErrOutputWrite, err)
+		}
+	}
+
+	return nil
+}
+
+// Reset restores all parameters to their default values.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) Reset() *CWebP {
+	c.crop = nil
+	c.resize = nil
+	c.resizeFirst = false
+	c.linearDepth = false
+	c.aspect = nil
+	c.canvas = nil
+	c.orient = 0
+	c.preset = ""
+	c.quality = -1
+	c.targetSize = 0
+	c.targetPSNR = 0
+	c.passes = 0
+	c.method = -1
+	c.autoFilter = false
+	c.filter = -1
+	c.sns = -1
+	c.sharpYUV = false
+	c.lowMemory = false
+	c.jpegLike = false
+	c.partLimit = -1
+	c.alphaQ = -1
+	c.alphaFilter = AlphaFilterDefault
+	c.hint = ImageHintDefault
+	c.lossless = false
+	c.exact = false
+	c.effort = -1
+	c.nearLossless = -1
+	c.threads = 0
+	c.mt = false
+	c.optimizeAlpha = false
+	c.detectGray = false
+	c.copyMetadata = false
+	c.metadata = nil
+	c.placeholder = false
+	c.newHash = nil
+	c.optionCheck = OptionCheckOff
+	c.preprocess = nil
+	c.verbose = false
+	c.collectStats = false
+	c.printPSNR = false
+	c.summaryInput = false
+	c.skipIfLarger = false
+	c.skipUnchanged = false
+	c.sizeLimit = nil
+	c.fallback = nil
+	return c
+}
+
+// runWithSizeLimit searches for the highest quality whose output fits into the size limit
+// and writes that output to the configured destination.
+func (c *CWebP) runWithSizeLimit(ctx context.Context) error {
+	c.chosenQuality = -1
+
+	limit, quality := c.sizeLimit, c.quality
+	var chosen uint
+	err := c.runAttempts(ctx, func(encode func() ([]byte, error)) ([]byte, *encodeSummary, error) {
+		summaries := map[uint]*encodeSummary{}
+		encodeAt := func(q uint) ([]byte, error) {
+			c.quality = int(q)
+			result, err := encode()
+			summaries[q] = c.summary
+			return result, err
+		}
+
+		high := uint(75)
+		if quality > -1 {
+			high = uint(quality)
+		}
+		low := min(limit.minQuality, high)
+
+		best, err := encodeAt(high)
+		if err != nil {
+			return nil, nil, err
+		}
+		chosen = high
+
+		if len(best) > limit.maxBytes {
+			if best, err = encodeAt(low); err != nil {
+				return nil, nil, err
+			}
+			if len(best) > limit.maxBytes {
+				return nil, nil, fmt.Errorf("%w: %d bytes at quality %d exceed the limit of %d bytes",
+					ErrCannotMeetSize, len(best), low, limit.maxBytes)
+			}
+			chosen = low
+
+			// Invariant: low fits into the limit, high doesn't.
+			for high-low > 1 {
+				mid := low + (high-low)/2
+				result, err := encodeAt(mid)
+				if err != nil {
+					return nil, nil, err
+				}
+				if len(result) <= limit.maxBytes {
+					low, best, chosen = mid, result, mid
+				} else {
+					high = mid
+				}
+			}
+		}
+
+		return best, summaries[chosen], nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.chosenQuality = int(chosen)
+	return nil
+}
+
+// runWithFallback encodes losslessly and re-encodes lossy if the lossless output exceeds the budget,
+// then writes the chosen output to the configured destination.
+func (c *CWebP) runWithFallback(ctx context.Context) error {
+	c.usedLossy = false
+
+	fallback := c.fallback
+	var lossy bool
+	err := c.runAttempts(ctx, func(encode func() ([]byte, error)) ([]byte, *encodeSummary, error) {
+		c.lossless = true
+		result, err := encode()
+		if err != nil || len(result) <= fallback.maxBytes {
+			return result, c.summary, err
+		}
+
+		c.lossless, c.exact, c.effort, c.nearLossless = false, false, -1, -1
+		c.quality = int(fallback.quality)
+		lossy = true
+		result, err = encode()
+		return result, c.summary, err
+	})
+	if err != nil {
+		return err
+	}
+
+	c.usedLossy = lossy
+	return nil
+}
+
+// runAttempts encodes the input in memory as often as search requests and writes the result
+// chosen by search to the configured destination. Search may change the encoding options
+// between the attempts; they are restored afterwards.
+func (c *CWebP) runAttempts(ctx context.Context, search func(encode func() ([]byte, error)) ([]byte, *encodeSummary, error)) error {
+	c.summary = nil
+	c.outputHash = ""
+
+	limit, fallback, newHash := c.sizeLimit, c.fallback, c.newHash
+	quality, lossless, exact, effort, input := c.quality, c.lossless, c.exact, c.effort, c.input
+	nearLossless := c.nearLossless
+	output, outputFile, pngOutput, skipIfLarger := c.output, c.outputFile, c.pngOutput, c.skipIfLarger
+	defer func() {
+		c.sizeLimit, c.fallback, c.newHash = limit, fallback, newHash
+		c.quality, c.lossless, c.exact, c.effort, c.input = quality, lossless, exact, effort, input
+		c.nearLossless = nearLossless
+		c.output, c.outputFile, c.pngOutput, c.skipIfLarger = output, outputFile, pngOutput, skipIfLarger
+	}()
+
+	if output == nil && outputFile == "" {
+		return errors.New("failed to get output: undefined output")
+	}
+	if skipIfLarger && (c.inputFile == "" || outputFile == "") {
+		return errors.New("SkipIfLarger requires an input file and an output file")
+	}
+	if c.skipUnchanged {
+		return errors.New("SkipIfUnchanged can't be combined with MaxOutputBytes or LosslessOrLossy")
+	}
+	if pngOutput != nil && c.inputImage == nil {
+		return errors.New("failed to prepare input image: PNG copy requires an input image set with InputImage")
+	}
+
+	var data []byte
+	if input != nil {
+		var err error
+		if data, err = io.ReadAll(input); err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+	}
+
+	// The attempts only produce the WebP data; the other outputs are created for the final result.
+	c.sizeLimit, c.fallback, c.newHash, c.pngOutput, c.skipIfLarger = nil, nil, nil, nil, false
+	encode := func() ([]byte, error) {
+		var b bytes.Buffer
+		if input != nil {
+			c.input = bytes.NewReader(data)
+		}
+		c.Output(&b)
+		if err := c.RunWithContext(ctx); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	best, summary, err := search(encode)
+	if err != nil {
+		return err
+	}
+
+	c.outputFile = outputFile
+	inPlace := output == nil && c.inPlace()
+	if inPlace && skipIfLarger {
+		// The input file is replaced by the output, so it has to be compared beforehand.
+		info, err := os.Stat(c.inputFile)
+		if err != nil {
+			return fmt.Errorf("failed to stat input file: %w", err)
+		}
+		c.skipped = int64(len(best)) > info.Size()
+	}
+
+	switch {
+	case output != nil:
+		if _, err := output.Write(best); err != nil {
+			return fmt.Errorf("%w: %w", ErrOutputWrite, err)
+		}
+	case c.skipped:
+	case inPlace:
+		tmp, err := tempOutputFile(outputFile)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+		if err := os.WriteFile(tmp, best, 0644); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		if err := replaceFile(tmp, outputFile); err != nil {
+			return err
+		}
+	default:
+		if err := os.WriteFile(outputFile, best, 0644); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+	}
+
+	if pngOutput != nil {
+		img, err := c.prepareImage()
+		if err != nil {
+			return fmt.Errorf("failed to prepare input image: %w", err)
+		}
+		pngCopy, err := encodePNGCopy(img)
+		if err != nil {
+			return err
+		}
+		if _, err := pngOutput.Write(pngCopy); err != nil {
+			return fmt.Errorf("%w: %w", ErrOutputWrite, err)
+		}
+	}
+
+	c.summary = summary
+
+	if skipIfLarger && !inPlace {
+		if err := c.discardIfLarger(outputFile); err != nil {
+			return err
+		}
+	}
+
+	// The result is in memory, so it is hashed directly.
+	if newHash != nil && !c.skipped {
+		h := newHash()
+		h.Write(best)
+		c.outputHash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return nil
+}
+
+// grayscale returns the image as *image.Gray if it holds grayscale content.
+// Images of other types than *image.Gray and *image.Gray16 are only scanned if scan is set.
+func grayscale(img image.Image, scan bool) (*image.Gray, bool) {
+	switch m := img.(type) {
+	case *image.Gray:
+		return m, true
+	case *image.Gray16:
+	default:
+		if !scan || !isGray(img) {
+			return nil, false
+		}
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	draw.Draw(gray, bounds, img, bounds.Min, draw.Src)
+	return gray, true
+}
+
+// isGray reports whether all pixels of the image are opaque and have equal red, green and blue values.
+func isGray(img image.Image) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a != 0xffff || r != g || g != b {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// orient returns the image rotated and flipped to display it upright according to the EXIF orientation.
+func orient(img image.Image, orientation int) (image.Image, error) {
+	if orientation < 1 || orientation > 8 {
+		return nil, fmt.Errorf("invalid orientation %d, expected 1 to 8", orientation)
+	}
+	if orientation == 1 {
+		return img, nil
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Orientations 5 to 8 swap the width and height.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			// The source pixel shown at dx, dy.
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				sx, sy = w-1-dx, dy
+			case 3: // Rotated by 180 degrees
+				sx, sy = w-1-dx, h-1-dy
+			case 4: // Mirrored vertically
+				sx, sy = dx, h-1-dy
+			case 5: // Transposed
+				sx, sy = dy, dx
+			case 6: // Rotated by 90 degrees counterclockwise
+				sx, sy = dy, h-1-dx
+			case 7: // Transversed
+				sx, sy = w-1-dy, h-1-dx
+			case 8: // Rotated by 90 degrees clockwise
+				sx, sy = w-1-dy, dx
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+
+	return dst, nil
+}
+
+// encodePNGCopy encodes the image as PNG with the default compression level.
+func encodePNGCopy(img image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	enc := &png.Encoder{CompressionLevel: png.DefaultCompression}
+	if err := enc.Encode(&buffer, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG copy: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// placeholderSize is the length of the longer side of placeholders in pixels.
+const placeholderSize = 16
+
+// placeholderDataURI downscales the area of the image selected by crop, or the whole image
+// if crop is nil, to a blurred thumbnail and returns it as a PNG data URI.
+func placeholderDataURI(img image.Image, crop *cropInfo) (string, error) {
+	bounds := img.Bounds()
+	if crop != nil {
+		bounds = image.Rect(crop.x, crop.y, crop.x+crop.width, crop.y+crop.height).Add(bounds.Min).Intersect(bounds)
+		if bounds.Empty() {
+			return "", errors.New("failed to create placeholder: crop area outside of the image")
+		}
+	}
+
+	width, height := placeholderSize, placeholderSize
+	if bounds.Dx() > bounds.Dy() {
+		height = max(1, bounds.Dy()*placeholderSize/bounds.Dx())
+	} else {
+		width = max(1, bounds.Dx()*placeholderSize/bounds.Dy())
+	}
+	width, height = min(width, bounds.Dx()), min(height, bounds.Dy())
+
+	// Average a grid of at most 8x8 samples per pixel, which bounds the cost for large images.
+	small := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := bounds.Min.Y+y*bounds.Dy()/height, bounds.Min.Y+(y+1)*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := bounds.Min.X+x*bounds.Dx()/width, bounds.Min.X+(x+1)*bounds.Dx()/width
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy += max(1, (y1-y0)/8) {
+				for sx := x0; sx < x1; sx += max(1, (x1-x0)/8) {
+					sr, sg, sb, sa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+sr, g+sg, b+sb, a+sa, n+1
+				}
+			}
+			small.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+
+	// Blur the thumbnail with a 3x3 box filter clamped to the edges.
+	blurred := image.NewRGBA(small.Bounds())
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum [4]int
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					p := small.RGBAAt(min(max(x+dx, 0), width-1), min(max(y+dy, 0), height-1))
+					sum[0], sum[1], sum[2], sum[3] = sum[0]+int(p.R), sum[1]+int(p.G), sum[2]+int(p.B), sum[3]+int(p.A)
+				}
+			}
+			blurred.SetRGBA(x, y, color.RGBA{uint8(sum[0] / 9), uint8(sum[1] / 9), uint8(sum[2] / 9), uint8(sum[3] / 9)})
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, blurred); err != nil {
+		return "", fmt.Errorf("failed to encode placeholder: %w", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+// aspectCrop computes the crop area set with CropToAspect from the dimensions of the input.
+// For reader input the header is read ahead and kept for cwebp.
+func (c *CWebP) aspectCrop(img image.Image) (*cropInfo, error) {
+	var width, height int
+	switch {
+	case img != nil:
+		width, height = img.Bounds().Dx(), img.Bounds().Dy()
+	case c.input != nil:
+		header := make([]byte, sniffSize)
+		n, err := io.ReadFull(c.input, header)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+		c.input = io.MultiReader(bytes.NewReader(header[:n]), c.input)
+		_, width, height = sniffImage(header[:n])
+	case c.inputFile != "":
+		f, err := os.Open(c.inputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input file: %w", err)
+		}
+		header, err := io.ReadAll(io.LimitReader(f, sniffSize))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input file: %w", err)
+		}
+		_, width, height = sniffImage(header)
+	default:
+		return nil, errors.New("failed to set input: undefined input")
+	}
+
+	if width == 0 || height == 0 {
+		return nil, errors.New("CropToAspect requires an input whose dimensions can be determined")
+	}
+
+	rect := AspectCrop(width, height, c.aspect.X, c.aspect.Y)
+	if rect.Empty() {
+		return nil, fmt.Errorf("invalid aspect ratio %d:%d", c.aspect.X, c.aspect.Y)
+	}
+	return &cropInfo{rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy()}, nil
+}
+
+// inPlace reports whether the output file is the input file.
+func (c *CWebP) inPlace() bool {
+	if c.inputFile == "" || c.outputFile == "" {
+		return false
+	}
+	input, err := os.Stat(c.inputFile)
+	if err != nil {
+		return false
+	}
+	output, err := os.Stat(c.outputFile)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(input, output)
+}
+
+// tempOutputFile creates an empty temporary file in the directory of path and returns its name.
+func tempOutputFile(path string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary output file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to create temporary output file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// replaceFile atomically replaces path with the file tmp, keeping the file mode of path.
+func replaceFile(tmp, path string) error {
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to replace output file: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace output file: %w", err)
+	}
+	return nil
+}
+
+// discardIfLarger removes the output file if it is larger than the input file.
+func (c *CWebP) discardIfLarger(outputFile string) error {
+	input, err := os.Stat(c.inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat input file: %w", err)
+	}
+
+	output, err := os.Stat(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat output file: %w", err)
+	}
+
+	if output.Size() <= input.Size() {
+		return nil
+	}
+
+	if err := os.Remove(outputFile); err != nil {
+		return fmt.Errorf("failed to remove output file: %w", err)
+	}
+	c.skipped = true
+	return nil
+}
+
+// hashSuffix is the suffix of the sidecar files holding the hash of the input of an output file.
+const hashSuffix = ".sha256"
+
+// inputHash returns the hex-encoded SHA-256 hash of the cwebp arguments set so far and the input,
+// which is either the prepared image or the input reader or file. Reader input is buffered
+// and replaced with the buffer for cwebp.
+func (c *CWebP) inputHash(img image.Image) (string, error) {
+	h := sha256.New()
+	for _, arg := range c.Args() {
+		io.WriteString(h, arg)
+		h.Write([]byte{0})
+	}
+
+	switch {
+	case img != nil:
+		bounds := img.Bounds()
+		fmt.Fprintf(h, "image %dx%d\x00", bounds.Dx(), bounds.Dy())
+		row := make([]byte, 0, bounds.Dx()*8)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row = row[:0]
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := img.At(x, y).RGBA()
+				row = binary.BigEndian.AppendUint16(row, uint16(r))
+				row = binary.BigEndian.AppendUint16(row, uint16(g))
+				row = binary.BigEndian.AppendUint16(row, uint16(b))
+				row = binary.BigEndian.AppendUint16(row, uint16(a))
+			}
+			h.Write(row)
+		}
+	case c.input != nil:
+		data, err := io.ReadAll(c.input)
+		if err != nil {
+			return "", fmt.Errorf("failed to read input: %w", err)
+		}
+		c.input = bytes.NewReader(data)
+		h.Write(data)
+	default:
+		f, err := os.Open(c.inputFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash input file: %w", err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", fmt.Errorf("failed to hash input file: %w", err)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isUnchanged reports whether the output file exists and its sidecar file holds the hash.
+func isUnchanged(outputFile, hash string) bool {
+	stored, err := os.ReadFile(outputFile + hashSuffix)
+	if err != nil || strings.TrimSpace(string(stored)) != hash {
+		return false
+	}
+	_, err = os.Stat(outputFile)
+	return err == nil
+}
+
+// writeSidecarHash stores the hash in the sidecar file of the output file.
+// If the output was discarded, a stale sidecar file is removed instead.
+func writeSidecarHash(outputFile, hash string, discarded bool) error {
+	if discarded {
+		if err := os.Remove(outputFile + hashSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove hash file: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(outputFile+hashSuffix, []byte(hash+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write hash file: %w", err)
+	}
+	return nil
+}
+
+// prepareImage applies the in-memory transformations to the image set with InputImage.
+// Returns nil if the input is not an in-memory image.
+func (c *CWebP) prepareImage() (image.Image, error) {
+	if c.inputErr != nil {
+		return nil, c.inputErr
+	}
+
+	if c.inputImage == nil {
+		if c.canvas != nil {
+			return nil, errors.New("canvas requires an input image set with InputImage")
+		}
+		if c.orient != 0 {
+			return nil, errors.New("orientation requires an input image set with InputImage")
+		}
+		if c.pngOutput != nil {
+			return nil, errors.New("PNG copy requires an input image set with InputImage")
+		}
+		if c.placeholder {
+			return nil, errors.New("placeholder requires an input image set with InputImage")
+		}
+		if len(c.preprocess) > 0 {
+			return nil, errors.New("preprocessing requires an input image set with InputImage")
+		}
+		if c.resizeInMemory() {
+			return nil, errors.New("resizing before cropping requires an input image set with InputImage")
+		}
+		return nil, nil
+	}
+
+	img := c.inputImage
+	if err := checkImageSize(img); err != nil {
+		return nil, err
+	}
+
+	if c.linearDepth && is16Bit(img) {
+		img = downsampleLinear(img)
+	}
+
+	if c.orient != 0 {
+		var err error
+		if img, err = orient(img, c.orient); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.resizeInMemory() {
+		var err error
+		if img, err = resizeImage(img, *c.resize); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.canvas != nil {
+		bounds := img.Bounds()
+		target := image.Rect(c.canvas.offsetX, c.canvas.offsetY,
+			c.canvas.offsetX+bounds.Dx(), c.canvas.offsetY+bounds.Dy())
+		canvas := image.NewNRGBA(image.Rect(0, 0, c.canvas.width, c.canvas.height))
+
+		if c.canvas.width <= 0 || c.canvas.height <= 0 || !target.In(canvas.Bounds()) {
+			return nil, fmt.Errorf("image of size %dx%d at offset %d,%d does not fit on a %dx%d canvas",
+				bounds.Dx(), bounds.Dy(), c.canvas.offsetX, c.canvas.offsetY, c.canvas.width, c.canvas.height)
+		}
+
+		draw.Draw(canvas, target, img, bounds.Min, draw.Src)
+		img = canvas
+	}
+
+	for i, hook := range c.preprocess {
+		var err error
+		if img, err = hook(img); err != nil {
+			return nil, fmt.Errorf("preprocessing hook %d: %w", i+1, err)
+		}
+		if img == nil {
+			return nil, fmt.Errorf("preprocessing hook %d returned no image", i+1)
+		}
+		if err := checkImageSize(img); err != nil {
+			return nil, fmt.Errorf("preprocessing hook %d: %w", i+1, err)
+		}
+	}
+
+	return img, nil
+}
+
+// resizeInMemory reports whether the image has to be resized in memory, because it is
+// to be resized before cropping it.
+func (c *CWebP) resizeInMemory() bool {
+	return c.resizeFirst && c.resize != nil && (c.crop != nil || c.aspect != nil)
+}
+
+// resizeImage scales the image to the given size like cwebp does for -resize:
+// a dimension of 0 is computed from the other one to preserve the aspect ratio.
+func resizeImage(img image.Image, size image.Point) (image.Image, error) {
+	size, err := resizeDimensions(size)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	switch {
+	case size.X == 0 && size.Y == 0:
+		return img, nil
+	case size.X == 0:
+		size.X = max(1, (bounds.Dx()*size.Y+bounds.Dy()/2)/bounds.Dy())
+	case size.Y == 0:
+		size.Y = max(1, (bounds.Dy()*size.X+bounds.Dx()/2)/bounds.Dx())
+	}
+
+	resized := image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+	xdraw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, xdraw.Src, nil)
+	return resized, nil
+}
+
+// resizeDimensions returns the dimensions set with Resize with negative dimensions replaced by 0,
+// which computes them from the other one. Returns an error if both dimensions are negative.
+func resizeDimensions(size image.Point) (image.Point, error) {
+	if size.X < 0 && size.Y < 0 {
+		return image.Point{}, fmt.Errorf("invalid resize dimensions %dx%d", size.X, size.Y)
+	}
+	return image.Point{max(size.X, 0), max(size.Y, 0)}, nil
+}
+
+// setInput configures the input source for the cwebp command.
+// The prepared image, if any, takes the place of the image set with InputImage.
+// If encoded is not nil, it holds the image already encoded as PNG and is used instead of encoding it again.
+// Returns the reader to pass as the standard input of cwebp, nil for input files,
+// and an error if no input source is defined.
+func (c *CWebP) setInput(img image.Image, encoded []byte) (io.Reader, error) {
+	if c.input != nil {
+		c.Arg("--").Arg("-")
+		return c.input, nil
+	} else if encoded != nil {
 		c.Arg("--").Arg("-")
-		c.StdIn(c.input)
-	} else if c.inputImage != nil {
-		r, err := createReaderFromImage(c.inputImage)
+		return bytes.NewReader(encoded), nil
+	} else if img != nil {
+		r, err := createReaderFromImage(img)
 		if err != nil {
-			return fmt.Errorf("failed to create reader from image: %w", err)
+			return nil, fmt.Errorf("failed to create reader from image: %w", err)
 		}
 		c.Arg("--").Arg("-")
-		c.StdIn(r)
+		return r, nil
 	} else if c.inputFile != "" {
 		c.Arg(c.inputFile)
-	} else {
-		return errors.New("undefined input")
+		return nil, nil
 	}
-	return nil
+	return nil, errors.New("undefined input")
 }
 
 // getOutput determines the output destination for the cwebp command.
@@ -226,3 +2185,37 @@ func (c *CWebP) getOutput() (string, error) {
 		return "", errors.New("undefined output")
 	}
 }
+
+// parseTimings extracts the "Time to ...: <duration>" lines printed by cwebp in verbose mode.
+// Lines that don't look like timings are ignored, so additional or reordered output
+// of other libwebp versions doesn't break the parsing.
+func parseTimings(stderr []byte) *EncodeTimings {
+	timings := &EncodeTimings{Stages: map[string]time.Duration{}}
+
+	for _, match := range timingPattern.FindAllStringSubmatch(string(stderr), -1) {
+		value, err := strconv.ParseFloat(strings.Replace(match[2], ",", ".", 1), 64)
+		if err != nil {
+			continue
+		}
+
+		unit := time.Second
+		if strings.EqualFold(match[3], "ms") {
+			unit = time.Millisecond
+		}
+		duration := time.Duration(value * float64(unit))
+
+		stage := strings.ToLower(match[1])
+		timings.Stages[stage] = duration
+
+		switch {
+		case strings.Contains(stage, "read"):
+			timings.ReadInput = duration
+		case strings.Contains(stage, "encode"):
+			timings.Encode = duration
+		case strings.Contains(stage, "write"):
+			timings.WriteOutput = duration
+		}
+	}
+
+	return timings
+}
@@ -1,14 +1,36 @@
 package webpwrap
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"image"
+	"image/color"
+	"image/draw"
 	"image/jpeg"
+	"image/png"
 	"io"
+	"math"
+	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
 	"golang.org/x/image/webp"
 )
 
@@ -47,6 +69,24 @@ func downloadFile(url, target string) {
 	}
 }
 
+var errWriterClosed = errors.New("writer closed")
+
+// failingWriter fails once more than limit bytes have been written.
+type failingWriter struct {
+	limit   int
+	written int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		n := w.limit - w.written
+		w.written = w.limit
+		return n, errWriterClosed
+	}
+	w.written += len(p)
+	return len(p), nil
+}
+
 func TestEncodeImage(t *testing.T) {
 	c := NewCWebP()
 	f, err := os.Open("source.jpg")
@@ -94,6 +134,1738 @@ func TestEncodeWriter(t *testing.T) {
 	validateWebp(t)
 }
 
+func TestEncodeWriterError(t *testing.T) {
+	c := NewCWebP()
+	c.InputFile("source.jpg")
+	c.Output(&failingWriter{limit: 100})
+	err := c.Run()
+	assert.ErrorIs(t, err, ErrOutputWrite)
+	assert.ErrorIs(t, err, errWriterClosed)
+	assert.NotContains(t, err.Error(), "stderr")
+}
+
+func TestEncodeOutputs(t *testing.T) {
+	var first, second bytes.Buffer
+	err := NewCWebP().InputFile("source.jpg").Outputs(&first, &second).Run()
+	assert.Nil(t, err)
+	assert.NotZero(t, first.Len())
+	assert.Equal(t, first.Bytes(), second.Bytes())
+
+	first.Reset()
+	err = NewCWebP().InputFile("source.jpg").Outputs(&first, &failingWriter{limit: 100}).Run()
+	assert.ErrorIs(t, err, ErrOutputWrite)
+	assert.ErrorIs(t, err, errWriterClosed)
+	assert.Contains(t, err.Error(), "output 1")
+
+	// Zero writers leave the output undefined.
+	err = NewCWebP().InputFile("source.jpg").Outputs().Run()
+	assert.NotNil(t, err)
+}
+
+// blockingWriter blocks every write until the context is done.
+type blockingWriter struct {
+	ctx context.Context
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.ctx.Done()
+	return 0, errWriterClosed
+}
+
+func TestEncodeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var b bytes.Buffer
+	c := NewCWebP()
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	err := c.RunWithContext(ctx)
+	assert.ErrorIs(t, err, ErrCanceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrTimeout)
+}
+
+func TestEncodeCanceledMidEncode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binary is a shell script")
+	}
+
+	// The binary hangs until it is killed.
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "cwebp"), []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	var b bytes.Buffer
+	c := NewCWebP(WithVendorPath(dir))
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	start := time.Now()
+	err := c.RunWithContext(ctx)
+	assert.ErrorIs(t, err, ErrCanceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Zero(t, b.Len())
+}
+
+// cancelingWriter cancels the context on the first write and accepts the output.
+type cancelingWriter struct {
+	bytes.Buffer
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.Buffer.Write(p)
+}
+
+func TestEncodeCanceledBeforeExit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The binary completes although the context was canceled while it ran.
+	c := NewCWebP()
+	c.InputFile("source.jpg")
+	c.Output(&cancelingWriter{cancel: cancel})
+	err := c.RunWithContext(ctx)
+	assert.ErrorIs(t, err, ErrCanceled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestEncodeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	c := NewCWebP()
+	c.InputFile("source.jpg")
+	c.Output(&blockingWriter{ctx})
+	err := c.RunWithContext(ctx)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrCanceled)
+	assert.NotErrorIs(t, err, ErrOutputWrite)
+}
+
+func TestEncodeSkipIfLarger(t *testing.T) {
+	// Two-color noise compresses well as a 1-bit PNG but poorly as lossy WebP.
+	img := image.NewPaletted(image.Rect(0, 0, 32, 32), color.Palette{color.Black, color.White})
+	r := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(r.Intn(2))
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "noise.png")
+	f, err := os.Create(input)
+	assert.Nil(t, err)
+	assert.Nil(t, (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(f, img))
+	f.Close()
+
+	output := filepath.Join(dir, "noise.webp")
+	c := NewCWebP().Quality(100).SkipIfLarger()
+	c.InputFile(input)
+	c.OutputFile(output)
+	err = c.Run()
+	assert.Nil(t, err)
+	assert.True(t, c.Skipped())
+	_, err = os.Stat(output)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(input)
+	assert.Nil(t, err)
+
+	c = NewCWebP().SkipIfLarger()
+	c.InputFile("source.jpg")
+	c.OutputFile(output)
+	err = c.Run()
+	assert.Nil(t, err)
+	assert.False(t, c.Skipped())
+	_, err = os.Stat(output)
+	assert.Nil(t, err)
+}
+
+func TestEncodeInPlace(t *testing.T) {
+	data, err := os.ReadFile("source.webp")
+	assert.Nil(t, err)
+	config, err := webp.DecodeConfig(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "image.webp")
+	assert.Nil(t, os.WriteFile(file, data, 0640))
+
+	c := NewCWebP().Quality(30)
+	c.InputFile(file)
+	c.OutputFile(file)
+	assert.Nil(t, c.Run())
+
+	f, err := os.Open(file)
+	assert.Nil(t, err)
+	img, err := webp.Decode(f)
+	f.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, config.Width, img.Bounds().Dx())
+	assert.Equal(t, config.Height, img.Bounds().Dy())
+
+	info, err := os.Stat(file)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+
+	// The same path written differently is detected as well.
+	c = NewCWebP().Quality(30).MaxOutputBytes(len(data), 0)
+	c.InputFile(file)
+	c.OutputFile(filepath.Join(dir, ".", "image.webp"))
+	assert.Nil(t, c.Run())
+	_, err = DecodeConfig(mustOpen(t, file))
+	assert.Nil(t, err)
+	entries, err = os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// mustOpen opens the file and closes it at the end of the test.
+func mustOpen(t *testing.T, name string) *os.File {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestEncodeSkipIfUnchanged(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "image.webp")
+	c := NewCWebP().Quality(60).SkipIfUnchanged()
+	c.InputFile("source.jpg")
+	c.OutputFile(output)
+	assert.Nil(t, c.Run())
+	assert.False(t, c.Unchanged())
+	_, err := DecodeConfig(mustOpen(t, output))
+	assert.Nil(t, err)
+	hash, err := os.ReadFile(output + ".sha256")
+	assert.Nil(t, err)
+
+	// Mark the output to detect whether it is written again.
+	assert.Nil(t, os.WriteFile(output, []byte("marker"), 0644))
+	assert.Nil(t, c.Run())
+	assert.True(t, c.Unchanged())
+	data, err := os.ReadFile(output)
+	assert.Nil(t, err)
+	assert.Equal(t, "marker", string(data))
+
+	// Changed options encode again and update the sidecar.
+	c.Quality(70)
+	assert.Nil(t, c.Run())
+	assert.False(t, c.Unchanged())
+	_, err = DecodeConfig(mustOpen(t, output))
+	assert.Nil(t, err)
+	updated, err := os.ReadFile(output + ".sha256")
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, updated)
+
+	// Streamed input is hashed by its content, like the file.
+	c.Input(mustOpen(t, "source.jpg"))
+	assert.Nil(t, c.Run())
+	assert.True(t, c.Unchanged())
+
+	var b bytes.Buffer
+	c.Output(&b)
+	assert.NotNil(t, c.Run())
+}
+
+func TestEncodeSkipIfLargerRequiresFiles(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().SkipIfLarger()
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	err := c.Run()
+	assert.NotNil(t, err)
+}
+
+// encodedSize returns the size of img encoded at the given quality.
+func encodedSize(t *testing.T, img image.Image, quality uint) int {
+	var b bytes.Buffer
+	err := NewCWebP().Quality(quality).InputImage(img).Output(&b).Run()
+	assert.Nil(t, err)
+	return b.Len()
+}
+
+func TestEncodeMaxOutputBytes(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	assert.Nil(t, err)
+	img, err := jpeg.Decode(f)
+	f.Close()
+	assert.Nil(t, err)
+
+	low, high := encodedSize(t, img, 10), encodedSize(t, img, 90)
+	assert.Less(t, low, high)
+	budget := (low + high) / 2
+
+	var b bytes.Buffer
+	c := NewCWebP().Quality(90).MaxOutputBytes(budget, 10)
+	c.InputImage(img)
+	c.Output(&b)
+	err = c.Run()
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, b.Len(), budget)
+	assert.Greater(t, c.ChosenQuality(), 10)
+	assert.Less(t, c.ChosenQuality(), 90)
+	assert.Equal(t, b.Len(), encodedSize(t, img, uint(c.ChosenQuality())))
+
+	imgTarget, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), imgTarget.Bounds())
+}
+
+func TestEncodeMaxOutputBytesImpossible(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().MaxOutputBytes(10, 0)
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	err := c.Run()
+	assert.ErrorIs(t, err, ErrCannotMeetSize)
+	assert.Zero(t, b.Len())
+	assert.Equal(t, -1, c.ChosenQuality())
+}
+
+func TestEncodeLosslessOrLossy(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	assert.Nil(t, err)
+	img, err := jpeg.Decode(f)
+	f.Close()
+	assert.Nil(t, err)
+
+	var lossless bytes.Buffer
+	assert.Nil(t, NewCWebP().Lossless().InputImage(img).Output(&lossless).Run())
+	lossy := encodedSize(t, img, 60)
+	assert.Less(t, lossy, lossless.Len())
+
+	// The photo exceeds the budget losslessly and falls back to lossy compression.
+	var b bytes.Buffer
+	c := NewCWebP().LosslessOrLossy(lossless.Len()-1, 60)
+	c.InputImage(img)
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+	assert.True(t, c.UsedLossyFallback())
+	assert.Equal(t, lossy, b.Len())
+	assert.False(t, c.summary.Lossless)
+
+	// Within the budget the lossless output is kept.
+	b.Reset()
+	c.LosslessOrLossy(lossless.Len(), 60)
+	assert.Nil(t, c.Run())
+	assert.False(t, c.UsedLossyFallback())
+	assert.Equal(t, lossless.Bytes(), b.Bytes())
+	assert.True(t, c.summary.Lossless)
+}
+
+func TestEncodeTargetSize(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) {}, []string{"-o"}},
+		{"size", func(c *CWebP) { c.TargetSize(20000) }, []string{"-size", "20000", "-o"}},
+		{"quality", func(c *CWebP) { c.TargetSize(20000).Quality(90) }, []string{"-q", "90", "-size", "20000", "-o"}},
+		{"negative", func(c *CWebP) { c.TargetSize(20000).TargetSize(-1) }, []string{"-o"}},
+		{"reset", func(c *CWebP) { c.TargetSize(20000).Reset() }, []string{"-o"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+			assert.Equal(t, tt.args, c.summary.Args[:len(tt.args)])
+		})
+	}
+}
+
+func TestEncodeTargetPSNR(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) {}, []string{"-o"}},
+		{"psnr", func(c *CWebP) { c.TargetPSNR(42) }, []string{"-psnr", "42.00", "-o"}},
+		{"precision", func(c *CWebP) { c.TargetPSNR(38.456) }, []string{"-psnr", "38.46", "-o"}},
+		{"negative", func(c *CWebP) { c.TargetPSNR(42).TargetPSNR(-1) }, []string{"-o"}},
+		{"reset", func(c *CWebP) { c.TargetPSNR(42).Reset() }, []string{"-o"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+			assert.Equal(t, tt.args, c.summary.Args[:len(tt.args)])
+		})
+	}
+}
+
+func TestEncodeTargetPSNRWithTargetSize(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().TargetSize(20000).TargetPSNR(42)
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	assert.NotNil(t, c.Run())
+	assert.Zero(t, b.Len())
+}
+
+func TestEncodeResize(t *testing.T) {
+	source, err := jpeg.DecodeConfig(mustOpen(t, "source.jpg"))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+		size image.Point // Expected size, 0 for dimensions following the aspect ratio
+	}{
+		{"unset", func(c *CWebP) {}, nil, image.Point{source.Width, source.Height}},
+		{"both", func(c *CWebP) { c.Resize(200, 100) }, []string{"-resize", "200", "100"}, image.Point{200, 100}},
+		{"width", func(c *CWebP) { c.Resize(100, 0) }, []string{"-resize", "100", "0"}, image.Point{100, 0}},
+		{"height", func(c *CWebP) { c.Resize(0, 100) }, []string{"-resize", "0", "100"}, image.Point{0, 100}},
+		{"negative width", func(c *CWebP) { c.Resize(-1, 100) }, []string{"-resize", "0", "100"}, image.Point{0, 100}},
+		{"negative height", func(c *CWebP) { c.Resize(100, -1) }, []string{"-resize", "100", "0"}, image.Point{100, 0}},
+		{"none", func(c *CWebP) { c.Resize(0, 0) }, nil, image.Point{source.Width, source.Height}},
+		{"reset", func(c *CWebP) { c.Resize(200, 100).Reset() }, nil, image.Point{source.Width, source.Height}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-resize")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+3])
+			}
+
+			config, err := webp.DecodeConfig(bytes.NewReader(b.Bytes()))
+			assert.Nil(t, err)
+			switch {
+			case tt.size.X == 0:
+				assert.Equal(t, tt.size.Y, config.Height)
+				assert.InDelta(t, source.Width*tt.size.Y/source.Height, config.Width, 1)
+			case tt.size.Y == 0:
+				assert.Equal(t, tt.size.X, config.Width)
+				assert.InDelta(t, source.Height*tt.size.X/source.Width, config.Height, 1)
+			default:
+				assert.Equal(t, tt.size, image.Point{config.Width, config.Height})
+			}
+		})
+	}
+
+	var b bytes.Buffer
+	c := NewCWebP().Resize(-1, -1)
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	assert.NotNil(t, c.Run())
+}
+
+func TestEncodeResizeThenCrop(t *testing.T) {
+	// The left half is red and the right half blue.
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(img, image.Rect(0, 0, 100, 100), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(100, 0, 200, 100), image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+
+	encode := func(c *CWebP) image.Image {
+		var b bytes.Buffer
+		c.Lossless().InputImage(img).Output(&b)
+		assert.Nil(t, c.Run())
+		decoded, err := webp.Decode(bytes.NewReader(b.Bytes()))
+		assert.Nil(t, err)
+		return decoded
+	}
+
+	// By default the crop area is taken from the source before resizing.
+	c := NewCWebP().Resize(40, 40).Crop(100, 0, 100, 100)
+	decoded := encode(c)
+	assert.Equal(t, image.Rect(0, 0, 40, 40), decoded.Bounds())
+	assert.Contains(t, c.summary.Args, "-resize")
+	r, _, b, _ := decoded.At(20, 20).RGBA()
+	assert.Less(t, r, b)
+
+	// With ResizeThenCrop the crop area is taken from the resized image.
+	c = NewCWebP().Resize(100, 0).Crop(50, 0, 50, 50).ResizeThenCrop()
+	decoded = encode(c)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), decoded.Bounds())
+	assert.NotContains(t, c.summary.Args, "-resize")
+	assert.Equal(t, []string{"-crop", "50", "0", "50", "50"}, c.summary.Args[slices.Index(c.summary.Args, "-crop"):][:5])
+	r, _, b, _ = decoded.At(25, 25).RGBA()
+	assert.Less(t, r, b)
+
+	var out bytes.Buffer
+	c = NewCWebP().Resize(100, 0).Crop(50, 0, 50, 50).ResizeThenCrop()
+	c.InputFile("source.jpg")
+	c.Output(&out)
+	assert.NotNil(t, c.Run())
+}
+
+func TestEncodePasses(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) { c.TargetSize(20000) }, []string{"-size", "20000", "-o"}},
+		{"passes", func(c *CWebP) { c.TargetSize(20000).Passes(6) }, []string{"-size", "20000", "-pass", "6", "-o"}},
+		{"clamped", func(c *CWebP) { c.TargetPSNR(40).Passes(25) }, []string{"-psnr", "40.00", "-pass", "10", "-o"}},
+		{"zero", func(c *CWebP) { c.TargetSize(20000).Passes(6).Passes(0) }, []string{"-size", "20000", "-o"}},
+		{"reset", func(c *CWebP) { c.Passes(6).Reset() }, []string{"-o"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+			assert.Equal(t, tt.args, c.summary.Args[:len(tt.args)])
+		})
+	}
+}
+
+func TestEncodeMethod(t *testing.T) {
+	tests := []struct {
+		name   string
+		method func(c *CWebP)
+		args   []string
+	}{
+		{"unset", func(c *CWebP) {}, nil},
+		{"fastest", func(c *CWebP) { c.Method(0) }, []string{"-m", "0"}},
+		{"default", func(c *CWebP) { c.Method(4) }, []string{"-m", "4"}},
+		{"slowest", func(c *CWebP) { c.Method(6) }, []string{"-m", "6"}},
+		{"clamped", func(c *CWebP) { c.Method(42) }, []string{"-m", "6"}},
+		{"reset", func(c *CWebP) { c.Method(2).Reset() }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.method(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-m")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+2])
+			}
+		})
+	}
+}
+
+func TestEncodePreset(t *testing.T) {
+	run := func(c *CWebP) error {
+		var b bytes.Buffer
+		return c.InputFile("source.jpg").Output(&b).Run()
+	}
+
+	// The preset precedes the parameters it would otherwise overwrite, whatever the call order.
+	c := NewCWebP().Quality(60).Method(2).Preset(PresetPhoto)
+	assert.Nil(t, run(c))
+	assert.Equal(t, []string{"-preset", "photo", "-q", "60", "-m", "2"}, c.summary.Args[:6])
+
+	c = NewCWebP().Preset(PresetText).Quality(60)
+	assert.Nil(t, run(c))
+	assert.Equal(t, []string{"-preset", "text", "-q", "60"}, c.summary.Args[:4])
+
+	c.Reset()
+	assert.Nil(t, run(c))
+	assert.NotContains(t, c.summary.Args, "-preset")
+
+	err := run(NewCWebP().Preset("blurry"))
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+}
+
+func TestEncodeFilterStrength(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) {}, nil},
+		{"zero", func(c *CWebP) { c.FilterStrength(0) }, []string{"-f", "0"}},
+		{"strength", func(c *CWebP) { c.FilterStrength(60) }, []string{"-f", "60"}},
+		{"clamped", func(c *CWebP) { c.FilterStrength(250) }, []string{"-f", "100"}},
+		{"auto", func(c *CWebP) { c.FilterStrength(60).AutoFilter(true) }, []string{"-f", "60", "-af"}},
+		{"reset", func(c *CWebP) { c.FilterStrength(0).Reset() }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-f")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+len(tt.args)])
+			}
+		})
+	}
+}
+
+func TestEncodeSpatialNoiseShaping(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) {}, nil},
+		{"zero", func(c *CWebP) { c.SpatialNoiseShaping(0) }, []string{"-sns", "0"}},
+		{"strength", func(c *CWebP) { c.SpatialNoiseShaping(80) }, []string{"-sns", "80"}},
+		{"clamped", func(c *CWebP) { c.SpatialNoiseShaping(101) }, []string{"-sns", "100"}},
+		{"reset", func(c *CWebP) { c.SpatialNoiseShaping(80).Reset() }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP().Preset(PresetPhoto)
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-sns")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+2])
+			}
+		})
+	}
+}
+
+func TestEncodeHint(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) {}, nil},
+		{"graph", func(c *CWebP) { c.Hint(ImageHintGraph) }, []string{"-hint", "graph"}},
+		{"photo", func(c *CWebP) { c.Hint(ImageHintPhoto) }, []string{"-hint", "photo"}},
+		{"default", func(c *CWebP) { c.Hint(ImageHintGraph).Hint(ImageHintDefault) }, nil},
+		{"reset", func(c *CWebP) { c.Hint(ImageHintGraph).Reset() }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-hint")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+2])
+			}
+		})
+	}
+
+	// The hint is passed along with the preset.
+	var b bytes.Buffer
+	c := NewCWebP().Hint(ImageHintGraph).Preset(PresetDrawing)
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+	assert.Subset(t, c.summary.Args, []string{"-preset", "drawing", "-hint", "graph"})
+
+	// Unknown hints are rejected instead of being passed to cwebp.
+	b.Reset()
+	c = NewCWebP().Hint(ImageHintPicture).Hint(ImageHint(7))
+	err := c.InputFile("source.jpg").Output(&b).Run()
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+	assert.ErrorContains(t, err, "ImageHint(7)")
+	assert.Zero(t, b.Len())
+	assert.ErrorIs(t, c.Hint(ImageHint(-1)).Run(), ErrUnsupportedOption)
+	assert.Equal(t, "ImageHint(7)", ImageHint(7).String())
+}
+
+func TestEncodeScreenshotMode(t *testing.T) {
+	// Black text on a white background, like a screenshot of a document.
+	img := image.NewNRGBA(image.Rect(0, 0, 320, 120))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	d := &font.Drawer{Dst: img, Src: image.Black, Face: basicfont.Face7x13}
+	for i, line := range []string{"The quick brown fox jumps over", "the lazy dog. 0123456789", "ScreenshotMode keeps text crisp."} {
+		d.Dot = fixed.P(8, 24+i*32)
+		d.DrawString(line)
+	}
+
+	encode := func(c *CWebP) ([]byte, float64) {
+		var b bytes.Buffer
+		assert.Nil(t, c.InputImage(img).Output(&b).Run())
+		decoded, err := NewDWebP().Input(bytes.NewReader(b.Bytes())).Run()
+		assert.Nil(t, err)
+
+		// The mean absolute difference of the gray levels measures how much the glyphs were blurred.
+		var diff float64
+		for y := 0; y < img.Bounds().Dy(); y++ {
+			for x := 0; x < img.Bounds().Dx(); x++ {
+				want := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+				got := color.GrayModel.Convert(decoded.At(x, y)).(color.Gray).Y
+				diff += math.Abs(float64(want) - float64(got))
+			}
+		}
+		return b.Bytes(), diff / float64(img.Bounds().Dx()*img.Bounds().Dy())
+	}
+
+	c := NewCWebP().ScreenshotMode()
+	screenshot, screenshotDiff := encode(c)
+	assert.Subset(t, c.summary.Args, []string{"-preset", "text", "-hint", "graph", "-lossless", "-near_lossless", "80"})
+	lossy, lossyDiff := encode(NewCWebP())
+
+	assert.Less(t, screenshotDiff, lossyDiff/4)
+	assert.Less(t, screenshotDiff, 1.0)
+	// Text compresses well without loss, so the output doesn't grow much.
+	assert.Less(t, len(screenshot), 2*len(lossy))
+}
+
+func TestEncodeAutoFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		af   bool
+	}{
+		{"unset", func(c *CWebP) {}, false},
+		{"enabled", func(c *CWebP) { c.AutoFilter(true) }, true},
+		{"disabled", func(c *CWebP) { c.AutoFilter(true).AutoFilter(false) }, false},
+		{"reset", func(c *CWebP) { c.AutoFilter(true).Reset() }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP().Quality(20)
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+			assert.Equal(t, tt.af, slices.Contains(c.summary.Args, "-af"))
+		})
+	}
+}
+
+func TestEncodeSharpYUV(t *testing.T) {
+	tests := []struct {
+		name     string
+		set      func(c *CWebP)
+		sharpYUV bool
+	}{
+		{"unset", func(c *CWebP) {}, false},
+		{"enabled", func(c *CWebP) { c.SharpYUV(true) }, true},
+		{"disabled", func(c *CWebP) { c.SharpYUV(true).SharpYUV(false) }, false},
+		{"reset", func(c *CWebP) { c.SharpYUV(true).Reset() }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+			assert.Equal(t, tt.sharpYUV, slices.Contains(c.summary.Args, "-sharp_yuv"))
+		})
+	}
+}
+
+func TestEncodeLowMemory(t *testing.T) {
+	tests := []struct {
+		name      string
+		set       func(c *CWebP)
+		lowMemory bool
+	}{
+		{"unset", func(c *CWebP) {}, false},
+		{"enabled", func(c *CWebP) { c.LowMemory(true) }, true},
+		{"disabled", func(c *CWebP) { c.LowMemory(true).LowMemory(false) }, false},
+		{"reset", func(c *CWebP) { c.LowMemory(true).Reset() }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+			assert.Equal(t, tt.lowMemory, slices.Contains(c.summary.Args, "-low_memory"))
+		})
+	}
+
+	// Lossless encoding doesn't reduce its memory usage.
+	c := NewCWebP().Lossless().LowMemory(true).InputImage(image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+	assert.ErrorContains(t, c.Validate(), "LowMemory has no effect with lossless compression")
+}
+
+func TestEncodeJPEGLike(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) { c.Quality(80) }, []string{"-q", "80"}},
+		{"enabled", func(c *CWebP) { c.Quality(80).JPEGLike(true) }, []string{"-q", "80", "-jpeg_like"}},
+		{"without quality", func(c *CWebP) { c.JPEGLike(true) }, []string{"-jpeg_like"}},
+		{"disabled", func(c *CWebP) { c.Quality(80).JPEGLike(true).JPEGLike(false) }, []string{"-q", "80"}},
+		{"reset", func(c *CWebP) { c.JPEGLike(true).Reset() }, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-o")
+			if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[:i])
+			}
+		})
+	}
+}
+
+func TestEncodePartitionLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) {}, nil},
+		{"no degradation", func(c *CWebP) { c.PartitionLimit(0) }, []string{"-partition_limit", "0"}},
+		{"limit", func(c *CWebP) { c.PartitionLimit(70) }, []string{"-partition_limit", "70"}},
+		{"clamped", func(c *CWebP) { c.PartitionLimit(250) }, []string{"-partition_limit", "100"}},
+		{"reset", func(c *CWebP) { c.PartitionLimit(70).Reset() }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-partition_limit")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+2])
+			}
+		})
+	}
+}
+
+func TestEncodeAlphaQuality(t *testing.T) {
+	transparent := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range transparent.Pix {
+		transparent.Pix[i] = byte(i)
+	}
+
+	tests := []struct {
+		name  string
+		set   func(c *CWebP)
+		input func(c *CWebP)
+		args  []string
+	}{
+		{"unset", func(c *CWebP) {}, func(c *CWebP) { c.InputImage(transparent) }, nil},
+		{"alpha", func(c *CWebP) { c.AlphaQuality(30) }, func(c *CWebP) { c.InputImage(transparent) }, []string{"-alpha_q", "30"}},
+		{"clamped", func(c *CWebP) { c.AlphaQuality(120) }, func(c *CWebP) { c.InputImage(transparent) }, []string{"-alpha_q", "100"}},
+		{"opaque", func(c *CWebP) { c.AlphaQuality(30) }, func(c *CWebP) { c.InputFile("source.jpg") }, []string{"-alpha_q", "30"}},
+		{"reset", func(c *CWebP) { c.AlphaQuality(30).Reset() }, func(c *CWebP) { c.InputImage(transparent) }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			tt.input(c)
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-alpha_q")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+2])
+			}
+		})
+	}
+}
+
+func TestEncodeAlphaFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) {}, nil},
+		{"default", func(c *CWebP) { c.AlphaFilter(AlphaFilterDefault) }, nil},
+		{"none", func(c *CWebP) { c.AlphaFilter(AlphaFilterNone) }, []string{"-alpha_filter", "none"}},
+		{"fast", func(c *CWebP) { c.AlphaFilter(AlphaFilterFast) }, []string{"-alpha_filter", "fast"}},
+		{"best", func(c *CWebP) { c.AlphaFilter(AlphaFilterBest) }, []string{"-alpha_filter", "best"}},
+		{"unknown", func(c *CWebP) { c.AlphaFilter(AlphaFilterBest).AlphaFilter(AlphaFilterMethod(42)) }, []string{"-alpha_filter", "best"}},
+		{"negative", func(c *CWebP) { c.AlphaFilter(AlphaFilterNone).AlphaFilter(AlphaFilterMethod(-1)) }, []string{"-alpha_filter", "none"}},
+		{"reset", func(c *CWebP) { c.AlphaFilter(AlphaFilterBest).Reset() }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-alpha_filter")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+2])
+			}
+		})
+	}
+
+	assert.Equal(t, "AlphaFilterMethod(42)", AlphaFilterMethod(42).String())
+}
+
+func TestEncodeNearLossless(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(c *CWebP)
+		args  []string
+		flags []string
+	}{
+		{"unset", func(c *CWebP) {}, nil, nil},
+		{"level", func(c *CWebP) { c.NearLossless(60) }, []string{"-near_lossless", "60"}, nil},
+		{"clamped", func(c *CWebP) { c.NearLossless(300) }, []string{"-near_lossless", "100"}, nil},
+		{"lossless", func(c *CWebP) { c.Lossless().NearLossless(40) }, []string{"-near_lossless", "40"}, []string{"-lossless"}},
+		{"reset", func(c *CWebP) { c.NearLossless(60).Reset() }, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-near_lossless")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+				assert.False(t, c.summary.Lossless)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+2])
+				assert.True(t, c.summary.Lossless)
+			}
+			for _, flag := range tt.flags {
+				assert.Contains(t, c.summary.Args, flag)
+			}
+		})
+	}
+}
+
+func TestEncodeLosslessEffort(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	for y := 16; y < 48; y++ {
+		for x := 16; x < 48; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: byte(x * 4), G: 30, B: byte(y * 4), A: 255})
+		}
+	}
+
+	encode := func(level uint) []byte {
+		var b bytes.Buffer
+		c := NewCWebP().LosslessEffort(level)
+		c.InputImage(img)
+		c.Output(&b)
+		assert.Nil(t, c.Run())
+		return b.Bytes()
+	}
+
+	fast, slow := encode(0), encode(9)
+	assert.LessOrEqual(t, len(slow), len(fast))
+
+	for _, data := range [][]byte{fast, slow} {
+		imgTarget, err := webp.Decode(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.Equal(t, img.NRGBAAt(20, 30), color.NRGBAModel.Convert(imgTarget.At(20, 30)))
+	}
+}
+
+func TestEncodeDataURI(t *testing.T) {
+	var source bytes.Buffer
+	assert.Nil(t, png.Encode(&source, image.NewNRGBA(image.Rect(0, 0, 12, 8))))
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(source.Bytes())
+
+	var b bytes.Buffer
+	c := NewCWebP()
+	c.InputDataURI(uri)
+	c.Output(&b)
+	err := c.Run()
+	assert.Nil(t, err)
+
+	imgTarget, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 12, 8), imgTarget.Bounds())
+}
+
+func TestEncodeDataURIInvalid(t *testing.T) {
+	for _, uri := range []string{
+		"image/png;base64,AAAA",
+		"data:image/png;base64",
+		"data:;base64,AAAA",
+		"data:text/plain;base64,AAAA",
+		"data:image/png;base64,not base64!",
+	} {
+		var b bytes.Buffer
+		c := NewCWebP()
+		c.InputDataURI(uri)
+		c.Output(&b)
+		err := c.Run()
+		assert.ErrorContains(t, err, "invalid data URI", uri)
+	}
+}
+
+func TestEncodeGrayscale(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			gray.SetGray(x, y, color.Gray{byte(x*3 + y)})
+		}
+	}
+	expanded := image.NewRGBA(gray.Bounds())
+	draw.Draw(expanded, expanded.Bounds(), gray, image.Point{}, draw.Src)
+
+	encode := func(img image.Image, detect bool) (*CWebP, []byte) {
+		var b bytes.Buffer
+		c := NewCWebP()
+		if detect {
+			c.DetectGrayscale()
+		}
+		c.InputImage(img)
+		c.Output(&b)
+		assert.Nil(t, c.Run())
+		return c, b.Bytes()
+	}
+
+	c, grayOutput := encode(gray, false)
+	assert.True(t, c.GrayscaleDetected())
+	c, expandedOutput := encode(expanded, false)
+	assert.False(t, c.GrayscaleDetected())
+	assert.LessOrEqual(t, len(grayOutput), len(expandedOutput))
+
+	c, detectedOutput := encode(expanded, true)
+	assert.True(t, c.GrayscaleDetected())
+	assert.Equal(t, len(grayOutput), len(detectedOutput))
+
+	// The grayscale intermediate is smaller than the RGB one.
+	grayPNG, err := createReaderFromImage(gray)
+	assert.Nil(t, err)
+	expandedPNG, err := createReaderFromImage(expanded)
+	assert.Nil(t, err)
+	assert.Less(t, grayPNG.(*bytes.Buffer).Len(), expandedPNG.(*bytes.Buffer).Len())
+
+	// Colored images are not detected.
+	expanded.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	c, _ = encode(expanded, true)
+	assert.False(t, c.GrayscaleDetected())
+}
+
+func TestEncodeLosslessExact(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256))
+		switch i / 4 % 3 {
+		case 0:
+			img.Pix[i+3] = 0 // Color values under fully transparent alpha
+		case 1:
+			img.Pix[i+3] = uint8(r.Intn(256))
+		default:
+			img.Pix[i+3] = 255
+		}
+	}
+
+	var encoded bytes.Buffer
+	err := NewCWebP().LosslessExact().InputImage(img).Output(&encoded).Run()
+	assert.Nil(t, err)
+
+	decoded, err := NewDWebP().Input(&encoded).Run()
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), decoded.Bounds())
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			assert.Equal(t, img.NRGBAAt(x, y), color.NRGBAModel.Convert(decoded.At(x, y)), "pixel %d,%d", x, y)
+		}
+	}
+}
+
+func TestEncodeExact(t *testing.T) {
+	// Opaque pixels on the left and fully transparent red pixels on the right.
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if x < 16 {
+				img.SetNRGBA(x, y, color.NRGBA{R: 40, G: 90, B: 200, A: 255})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{R: 220, G: 30, B: 30, A: 0})
+			}
+		}
+	}
+
+	encode := func(c *CWebP) image.Image {
+		var encoded bytes.Buffer
+		assert.Nil(t, c.InputImage(img).Output(&encoded).Run())
+		decoded, err := NewDWebP().Input(&encoded).Run()
+		assert.Nil(t, err)
+		return decoded
+	}
+
+	// Lossless exact output yields the color values under transparent areas unchanged.
+	decoded := encode(NewCWebP().Lossless().Exact(true))
+	for y := 0; y < 32; y++ {
+		for x := 16; x < 32; x++ {
+			assert.Equal(t, img.NRGBAAt(x, y), color.NRGBAModel.Convert(decoded.At(x, y)), "pixel %d,%d", x, y)
+		}
+	}
+
+	// Lossy exact output keeps them as far as the lossy encoding allows.
+	decoded = encode(NewCWebP().Quality(90).Exact(true))
+	transparent := color.NRGBAModel.Convert(decoded.At(24, 16)).(color.NRGBA)
+	assert.Equal(t, uint8(0), transparent.A)
+	assert.InDelta(t, 220, transparent.R, 16)
+	assert.InDelta(t, 30, transparent.G, 16)
+	assert.InDelta(t, 30, transparent.B, 16)
+
+	c := NewCWebP().Exact(true)
+	c.InputFile("source.jpg")
+	c.Output(&bytes.Buffer{})
+	assert.Nil(t, c.Run())
+	assert.Contains(t, c.summary.Args, "-exact")
+	assert.NotContains(t, c.summary.Args, "-lossless")
+
+	c.Reset()
+	c.InputFile("source.jpg")
+	c.Output(&bytes.Buffer{})
+	assert.Nil(t, c.Run())
+	assert.NotContains(t, c.summary.Args, "-exact")
+
+	c = NewCWebP().LosslessExact().Exact(false)
+	c.InputFile("source.jpg")
+	c.Output(&bytes.Buffer{})
+	assert.Nil(t, c.Run())
+	assert.Contains(t, c.summary.Args, "-lossless")
+	assert.NotContains(t, c.summary.Args, "-exact")
+}
+
+func TestAspectCrop(t *testing.T) {
+	// A wide image cropped to 1:1.
+	assert.Equal(t, image.Rect(280, 0, 1000, 720), AspectCrop(1280, 720, 1, 1))
+	// A tall image cropped to 16:9.
+	assert.Equal(t, image.Rect(0, 656, 1080, 1263), AspectCrop(1080, 1920, 16, 9))
+	// Matching aspect ratios keep the whole image.
+	assert.Equal(t, image.Rect(0, 0, 1920, 1080), AspectCrop(1920, 1080, 16, 9))
+	assert.True(t, AspectCrop(100, 100, 0, 1).Empty())
+}
+
+func TestEncodeCropToAspect(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	assert.Nil(t, err)
+	config, err := jpeg.DecodeConfig(f)
+	f.Close()
+	assert.Nil(t, err)
+	side := min(config.Width, config.Height)
+
+	var b bytes.Buffer
+	err = NewCWebP().CropToAspect(1, 1).InputFile("source.jpg").Output(&b).Run()
+	assert.Nil(t, err)
+	output, err := webp.DecodeConfig(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, side, output.Width)
+	assert.Equal(t, side, output.Height)
+
+	// The header of reader input is read ahead and passed on to cwebp.
+	f, err = os.Open("source.jpg")
+	assert.Nil(t, err)
+	defer f.Close()
+	b.Reset()
+	err = NewCWebP().CropToAspect(1, 1).Input(f).Output(&b).Run()
+	assert.Nil(t, err)
+	output, err = webp.DecodeConfig(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, side, output.Width)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 90))
+	b.Reset()
+	err = NewCWebP().CropToAspect(16, 9).InputImage(img).Output(&b).Run()
+	assert.Nil(t, err)
+	output, err = webp.DecodeConfig(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, 40, output.Width)
+	assert.Equal(t, 22, output.Height)
+
+	err = NewCWebP().CropToAspect(0, 1).InputImage(img).Output(&b).Run()
+	assert.ErrorContains(t, err, "aspect ratio")
+}
+
+func TestEncodeThreads(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binary is a shell script")
+	}
+
+	previousSkip := skipDownload
+	defer func() { skipDownload = previousSkip }()
+
+	// The fake binary writes its thread environment and arguments as output.
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"threads=$OMP_NUM_THREADS args=$*\"\n"
+	t.Setenv("OMP_NUM_THREADS", "")
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "cwebp"), []byte(script), 0755))
+	run := func(n int) string {
+		var b bytes.Buffer
+		c := NewCWebP(SetSkipDownload(true), WithVendorPath(dir)).Threads(n)
+		assert.Nil(t, c.InputFile("source.jpg").Output(&b).Run())
+		return b.String()
+	}
+
+	// The environment of the child is left alone.
+	output := run(4)
+	assert.Contains(t, output, "threads= ")
+	assert.Contains(t, output, "-mt")
+
+	output = run(1)
+	assert.Contains(t, output, "threads= ")
+	assert.NotContains(t, output, "-mt")
+
+	output = run(0)
+	assert.Contains(t, output, "threads= ")
+	assert.NotContains(t, output, "-mt")
+}
+
+func TestEncodeMultiThreading(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		mt   int
+	}{
+		{"unset", func(c *CWebP) {}, 0},
+		{"enabled", func(c *CWebP) { c.MultiThreading(true) }, 1},
+		{"repeated", func(c *CWebP) { c.MultiThreading(true).MultiThreading(true) }, 1},
+		{"threads", func(c *CWebP) { c.MultiThreading(true).Threads(4) }, 1},
+		{"disabled", func(c *CWebP) { c.MultiThreading(true).MultiThreading(false) }, 0},
+		{"reset", func(c *CWebP) { c.MultiThreading(true).Reset() }, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			var mt int
+			for _, arg := range c.summary.Args {
+				if arg == "-mt" {
+					mt++
+				}
+			}
+			assert.Equal(t, tt.mt, mt)
+		})
+	}
+}
+
+func TestEncodeOrientation(t *testing.T) {
+	// A 3x2 image with a distinct color in every pixel.
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 100), uint8(y * 200), 50, 255})
+		}
+	}
+	at := func(x, y int) color.NRGBA { return img.NRGBAAt(x, y) }
+
+	tests := []struct {
+		orientation int
+		expected    [][]color.NRGBA // Rows of the upright image
+	}{
+		// Stored rotated by 90 degrees counterclockwise, displayed rotated clockwise.
+		{6, [][]color.NRGBA{{at(0, 1), at(0, 0)}, {at(1, 1), at(1, 0)}, {at(2, 1), at(2, 0)}}},
+		// Stored rotated by 90 degrees clockwise, displayed rotated counterclockwise.
+		{8, [][]color.NRGBA{{at(2, 0), at(2, 1)}, {at(1, 0), at(1, 1)}, {at(0, 0), at(0, 1)}}},
+		{3, [][]color.NRGBA{{at(2, 1), at(1, 1), at(0, 1)}, {at(2, 0), at(1, 0), at(0, 0)}}},
+		{1, [][]color.NRGBA{{at(0, 0), at(1, 0), at(2, 0)}, {at(0, 1), at(1, 1), at(2, 1)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.orientation), func(t *testing.T) {
+			var b bytes.Buffer
+			err := NewCWebP().Lossless().Orientation(tt.orientation).InputImage(img).Output(&b).Run()
+			assert.Nil(t, err)
+			decoded, err := webp.Decode(&b)
+			assert.Nil(t, err)
+			assert.Equal(t, image.Rect(0, 0, len(tt.expected[0]), len(tt.expected)), decoded.Bounds())
+			for y, row := range tt.expected {
+				for x, expected := range row {
+					assert.Equal(t, expected, color.NRGBAModel.Convert(decoded.At(x, y)), "pixel %d,%d", x, y)
+				}
+			}
+		})
+	}
+
+	err := NewCWebP().Orientation(9).InputImage(img).Output(io.Discard).Run()
+	assert.ErrorContains(t, err, "invalid orientation")
+	err = NewCWebP().Orientation(6).InputFile("source.jpg").Output(io.Discard).Run()
+	assert.ErrorContains(t, err, "requires an input image")
+}
+
+func TestEncodePlaceholder(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	assert.Nil(t, err)
+	img, err := jpeg.Decode(f)
+	f.Close()
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	c := NewCWebP().WithPlaceholder()
+	c.InputImage(img)
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+	assert.NotZero(t, b.Len())
+
+	payload, ok := strings.CutPrefix(c.Placeholder(), "data:image/png;base64,")
+	assert.True(t, ok)
+	data, err := base64.StdEncoding.DecodeString(payload)
+	assert.Nil(t, err)
+	placeholder, err := png.Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+	bounds := img.Bounds()
+	assert.Equal(t, 16, max(placeholder.Bounds().Dx(), placeholder.Bounds().Dy()))
+	assert.Equal(t, bounds.Dx() > bounds.Dy(), placeholder.Bounds().Dx() > placeholder.Bounds().Dy())
+
+	// The placeholder follows the crop of the output.
+	c.Crop(0, 0, 100, 400)
+	assert.Nil(t, c.Run())
+	data, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(c.Placeholder(), "data:image/png;base64,"))
+	assert.Nil(t, err)
+	placeholder, err = png.Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 4, 16), placeholder.Bounds())
+
+	c.Reset()
+	assert.Nil(t, c.Run())
+	assert.Empty(t, c.Placeholder())
+}
+
+func TestEncodePlaceholderRequiresImage(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().WithPlaceholder()
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	assert.NotNil(t, c.Run())
+	assert.Empty(t, c.Placeholder())
+}
+
+func TestEncodePreprocess(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{200, 100, 20, 255}), image.Point{}, draw.Src)
+
+	invert := func(img image.Image) (image.Image, error) {
+		inverted := image.NewNRGBA(img.Bounds())
+		for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+			for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+				p := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				inverted.SetNRGBA(x, y, color.NRGBA{255 - p.R, 255 - p.G, 255 - p.B, p.A})
+			}
+		}
+		return inverted, nil
+	}
+
+	// The hooks run in order, the second one receives the inverted image.
+	var received color.Color
+	var b bytes.Buffer
+	c := NewCWebP().Lossless().Preprocess(invert).Preprocess(func(img image.Image) (image.Image, error) {
+		received = img.At(0, 0)
+		return img, nil
+	})
+	c.InputImage(img)
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+	assert.Equal(t, color.NRGBA{55, 155, 235, 255}, received)
+
+	imgTarget, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{55, 155, 235, 255}, color.NRGBAModel.Convert(imgTarget.At(4, 4)))
+
+	errHook := errors.New("denoising failed")
+	c.Preprocess(func(img image.Image) (image.Image, error) { return nil, errHook })
+	assert.ErrorIs(t, c.Run(), errHook)
+
+	c.Reset()
+	assert.Nil(t, c.InputFile("source.jpg").Run())
+	assert.NotNil(t, c.Preprocess(invert).Run())
+}
+
+func TestEncodeOutputHash(t *testing.T) {
+	sum := func(h hash.Hash, data []byte) string {
+		h.Write(data)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	output := filepath.Join(t.TempDir(), "image.webp")
+	c := NewCWebP().WithOutputHash(nil)
+	c.InputFile("source.jpg")
+	c.OutputFile(output)
+	assert.Nil(t, c.Run())
+	data, err := os.ReadFile(output)
+	assert.Nil(t, err)
+	_, err = webp.DecodeConfig(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, sum(sha256.New(), data), c.OutputHash())
+	assert.Equal(t, int64(len(data)), c.summary.OutputBytes)
+
+	var b bytes.Buffer
+	c.WithOutputHash(md5.New).Output(&b)
+	assert.Nil(t, c.Run())
+	assert.Equal(t, sum(md5.New(), b.Bytes()), c.OutputHash())
+
+	// The result of size limited runs is hashed once it is chosen.
+	b.Reset()
+	c.MaxOutputBytes(len(data), 0)
+	assert.Nil(t, c.Run())
+	assert.Equal(t, sum(md5.New(), b.Bytes()), c.OutputHash())
+
+	c.Reset()
+	assert.Nil(t, c.Run())
+	assert.Empty(t, c.OutputHash())
+}
+
+func TestEncodeRGBA(t *testing.T) {
+	width, height := 24, 16
+	pix := make([]byte, width*height*4)
+	for i := 0; i < width*height; i++ {
+		pix[i*4], pix[i*4+1], pix[i*4+2], pix[i*4+3] = byte(i), byte(i*3), byte(i*7), 255
+	}
+
+	var b bytes.Buffer
+	c := NewCWebP().Lossless()
+	c.InputRGBA(pix, width, height)
+	c.Output(&b)
+	err := c.Run()
+	assert.Nil(t, err)
+
+	imgTarget, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, width, height), imgTarget.Bounds())
+	for i := 0; i < width*height; i++ {
+		expected := color.NRGBA{pix[i*4], pix[i*4+1], pix[i*4+2], pix[i*4+3]}
+		assert.Equal(t, expected, color.NRGBAModel.Convert(imgTarget.At(i%width, i/width)))
+	}
+}
+
+func TestEncodeRGBAInvalidLength(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP()
+	c.InputRGBA(make([]byte, 10), 2, 2)
+	c.Output(&b)
+	err := c.Run()
+	assert.NotNil(t, err)
+}
+
+func TestEncodeAlsoWritePNG(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 24, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 24; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{byte(x * 10), byte(y * 15), byte(x + y), 255})
+		}
+	}
+
+	var webpOut, pngOut bytes.Buffer
+	c := NewCWebP().Lossless()
+	c.InputImage(img)
+	c.Output(&webpOut)
+	c.AlsoWritePNG(&pngOut)
+	err := c.Run()
+	assert.Nil(t, err)
+
+	webpImg, err := webp.Decode(bytes.NewReader(webpOut.Bytes()))
+	assert.Nil(t, err)
+	pngImg, err := png.Decode(bytes.NewReader(pngOut.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), webpImg.Bounds())
+	assert.Equal(t, img.Bounds(), pngImg.Bounds())
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 24; x++ {
+			expected := img.NRGBAAt(x, y)
+			assert.Equal(t, expected, color.NRGBAModel.Convert(webpImg.At(x, y)))
+			assert.Equal(t, expected, color.NRGBAModel.Convert(pngImg.At(x, y)))
+		}
+	}
+}
+
+func TestEncodeAlsoWritePNGRequiresImage(t *testing.T) {
+	var webpOut, pngOut bytes.Buffer
+	c := NewCWebP()
+	c.InputFile("source.jpg")
+	c.Output(&webpOut)
+	c.AlsoWritePNG(&pngOut)
+	err := c.Run()
+	assert.NotNil(t, err)
+	assert.Zero(t, pngOut.Len())
+}
+
+// addJPEGICCProfile embeds profile into the JPEG data as an APP2 ICC_PROFILE segment.
+func addJPEGICCProfile(jpegData, profile []byte) []byte {
+	segment := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...)
+	length := len(segment) + 2
+
+	var b bytes.Buffer
+	b.Write(jpegData[:2])
+	b.Write([]byte{0xff, 0xe2, byte(length >> 8), byte(length)})
+	b.Write(segment)
+	b.Write(jpegData[2:])
+	return b.Bytes()
+}
+
+func TestEncodeCopyMetadataFromInput(t *testing.T) {
+	source, err := os.ReadFile("source.jpg")
+	assert.Nil(t, err)
+	profile := buildMatrixTRCProfile(displayP3Primaries)
+	tagged := addJPEGICCProfile(source, profile)
+
+	var b bytes.Buffer
+	c := NewCWebP().CopyMetadataFromInput()
+	c.Input(bytes.NewReader(tagged))
+	c.Output(&b)
+	err = c.Run()
+	assert.Nil(t, err)
+
+	icc, err := readICCProfile(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, profile, icc)
+
+	// Sources without metadata are encoded normally.
+	b.Reset()
+	c = NewCWebP().CopyMetadataFromInput()
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	err = c.Run()
+	assert.Nil(t, err)
+	icc, err = readICCProfile(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Nil(t, icc)
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *CWebP)
+		args []string
+	}{
+		{"unset", func(c *CWebP) {}, nil},
+		{"single", func(c *CWebP) { c.Metadata(MetadataICC) }, []string{"-metadata", "icc"}},
+		{"joined", func(c *CWebP) { c.Metadata(MetadataEXIF, MetadataICC, MetadataXMP) }, []string{"-metadata", "exif,icc,xmp"}},
+		{"deduped", func(c *CWebP) { c.Metadata(MetadataXMP, MetadataICC, MetadataXMP) }, []string{"-metadata", "xmp,icc"}},
+		{"overrides copy", func(c *CWebP) { c.CopyMetadataFromInput().Metadata(MetadataICC) }, []string{"-metadata", "icc"}},
+		{"copy", func(c *CWebP) { c.CopyMetadataFromInput() }, []string{"-metadata", "all"}},
+		{"replaced", func(c *CWebP) { c.Metadata(MetadataEXIF).Metadata(MetadataNone) }, []string{"-metadata", "none"}},
+		{"reset", func(c *CWebP) { c.Metadata(MetadataICC).Reset() }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+
+			i := slices.Index(c.summary.Args, "-metadata")
+			if tt.args == nil {
+				assert.Equal(t, -1, i)
+			} else if assert.GreaterOrEqual(t, i, 0) {
+				assert.Equal(t, tt.args, c.summary.Args[i:i+2])
+			}
+		})
+	}
+
+	var b bytes.Buffer
+	c := NewCWebP().Metadata(MetadataICC, "gps")
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	assert.ErrorIs(t, c.Run(), ErrUnsupportedOption)
+}
+
+func TestOptimizeAlphaOpaque(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	var b bytes.Buffer
+	c := NewCWebP().OptimizeAlpha()
+	c.InputImage(img)
+	c.Output(&b)
+	err := c.Run()
+	assert.Nil(t, err)
+	assert.True(t, c.AlphaDropped())
+
+	imgTarget, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	_, ok := imgTarget.(*image.YCbCr)
+	assert.True(t, ok)
+}
+
+func TestOptimizeAlphaTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, A: uint8(x * 4)})
+		}
+	}
+
+	var b bytes.Buffer
+	c := NewCWebP().OptimizeAlpha()
+	c.InputImage(img)
+	c.Output(&b)
+	err := c.Run()
+	assert.Nil(t, err)
+	assert.False(t, c.AlphaDropped())
+
+	imgTarget, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	_, ok := imgTarget.(*image.NYCbCrA)
+	assert.True(t, ok)
+}
+
+func TestEncodeVerboseTimings(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().Verbose()
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	err := c.Run()
+	assert.Nil(t, err)
+
+	timings := c.Timings()
+	assert.NotNil(t, timings)
+	assert.NotEmpty(t, timings.Stages)
+	assert.True(t, timings.ReadInput > 0 || timings.Encode > 0)
+}
+
+func TestParseTimings(t *testing.T) {
+	stderr := "Saving file 'target.webp'\n" +
+		"Time to read input: 0.004s\n" +
+		"Time to encode picture: 0.052s\r\n" +
+		"File:      source.jpg\n" +
+		"Time to write output: 1,5ms\n" +
+		"Time to do something new: 0.100s\n"
+
+	timings := parseTimings([]byte(stderr))
+	assert.Equal(t, 4*time.Millisecond, timings.ReadInput)
+	assert.Equal(t, 52*time.Millisecond, timings.Encode)
+	assert.Equal(t, 1500*time.Microsecond, timings.WriteOutput)
+	assert.Equal(t, 100*time.Millisecond, timings.Stages["do something new"])
+	assert.Len(t, timings.Stages, 4)
+
+	timings = parseTimings([]byte("no timings here"))
+	assert.Empty(t, timings.Stages)
+	assert.Equal(t, time.Duration(0), timings.Encode)
+}
+
+func TestEncodeCanvas(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	var b bytes.Buffer
+	c := NewCWebP().Lossless().Canvas(64, 48, 10, 20)
+	c.InputImage(img)
+	c.Output(&b)
+	err := c.Run()
+	assert.Nil(t, err)
+
+	imgTarget, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 48), imgTarget.Bounds())
+
+	_, _, _, a := imgTarget.At(5, 5).RGBA()
+	assert.Equal(t, uint32(0), a)
+	r, g, _, a := imgTarget.At(18, 28).RGBA()
+	assert.Equal(t, uint32(0xffff), a)
+	assert.Equal(t, uint32(0xffff), r)
+	assert.Equal(t, uint32(0), g)
+}
+
+func TestEncodeCanvasTooSmall(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().Canvas(20, 20, 10, 0)
+	c.InputImage(image.NewNRGBA(image.Rect(0, 0, 16, 16)))
+	c.Output(&b)
+	err := c.Run()
+	assert.NotNil(t, err)
+
+	c = NewCWebP().Canvas(64, 64, 0, 0)
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	err = c.Run()
+	assert.NotNil(t, err)
+}
+
 func TestVersionCWebP(t *testing.T) {
 	c := NewCWebP()
 	r, err := c.Version()
@@ -4,14 +4,19 @@
 package webpwrap
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
+	"image/png"
 	"io"
+	"runtime"
+	"sync"
 )
 
 // Decode reads a WebP image from r and returns it as an image.Image.
-// It is a convenience function that wraps the DWebP decoder.
+// It is a convenience function that uses the backend set with SetBackend, which wraps the DWebP decoder by default.
 //
 // Parameters:
 //   - r: The io.Reader containing the WebP image data
@@ -25,7 +30,7 @@ func Decode(r io.Reader) (image.Image, error) {
 
 // DecodeWithContext reads a WebP image from r and returns it as an image.Image.
 // The context can be used to cancel the operation.
-// It is a convenience function that wraps the DWebP decoder.
+// It is a convenience function that uses the backend set with SetBackend, which wraps the DWebP decoder by default.
 //
 // Parameters:
 //   - ctx: The context for cancellation
@@ -35,9 +40,206 @@ func Decode(r io.Reader) (image.Image, error) {
 //   - image.Image: The decoded image
 //   - error: Any error encountered during decoding
 func DecodeWithContext(ctx context.Context, r io.Reader) (image.Image, error) {
-	img, err := NewDWebP().Input(r).RunWithContext(ctx)
+	img, err := backend().Decode(ctx, r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode WebP image: %w", err)
 	}
 	return img, nil
 }
+
+// DecodeAndReencodeFallback reads a WebP image from r and writes it to pngOut as a PNG
+// for clients without WebP support. The PNG is encoded with the best compression of image/png,
+// trading encoding time for a smaller fallback, which pays off when the fallback is
+// generated once and served many times. Fully opaque images are written without alpha channel.
+// It uses the backend set with SetBackend, like DecodeWithContext.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - r: The io.Reader containing the WebP image data
+//   - pngOut: The io.Writer the PNG is written to
+//
+// Returns:
+//   - error: Any error encountered during decoding or encoding
+func DecodeAndReencodeFallback(ctx context.Context, r io.Reader, pngOut io.Writer) error {
+	img, err := DecodeWithContext(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	enc := &png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(pngOut, img); err != nil {
+		return fmt.Errorf("failed to encode PNG fallback: %w", err)
+	}
+	return nil
+}
+
+// DecodeYCbCr reads a lossy WebP image from r and returns its Y'CbCr planes.
+// The planes are taken directly from the raw 4:2:0 output of dwebp (-yuv) without converting to RGB,
+// so the image can be passed on to video pipelines. The alpha channel, if any, is dropped.
+// Animations are not supported.
+//
+// Parameters:
+//   - r: The io.Reader containing the WebP image data
+//
+// Returns:
+//   - *image.YCbCr: The decoded image with 4:2:0 subsampling
+//   - error: Any error encountered during decoding
+func DecodeYCbCr(r io.Reader) (*image.YCbCr, error) {
+	return DecodeYCbCrWithContext(context.Background(), r)
+}
+
+// DecodeYCbCrWithContext reads a lossy WebP image from r and returns its Y'CbCr planes.
+// The context can be used to cancel the operation. See DecodeYCbCr.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - r: The io.Reader containing the WebP image data
+//
+// Returns:
+//   - *image.YCbCr: The decoded image with 4:2:0 subsampling
+//   - error: Any error encountered during decoding
+func DecodeYCbCrWithContext(ctx context.Context, r io.Reader) (*image.YCbCr, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP image: %w", err)
+	}
+
+	// The raw output has no header, so the dimensions are taken from the WebP header.
+	info, err := readWebPInfo(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP header: %w", err)
+	}
+	if info.animated {
+		return nil, errors.New("failed to decode WebP image: animations are not supported")
+	}
+
+	var out bytes.Buffer
+	d := NewDWebP().Input(bytes.NewReader(data)).Output(&out)
+	d.yuv = true
+	if _, err := d.RunWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to decode WebP image: %w", err)
+	}
+
+	img := image.NewYCbCr(image.Rect(0, 0, info.width, info.height), image.YCbCrSubsampleRatio420)
+	if out.Len() != len(img.Y)+len(img.Cb)+len(img.Cr) {
+		return nil, fmt.Errorf("failed to decode WebP image: unexpected YUV output size %d for %dx%d pixels",
+			out.Len(), info.width, info.height)
+	}
+
+	samples := out.Bytes()
+	copy(img.Y, samples)
+	copy(img.Cb, samples[len(img.Y):])
+	copy(img.Cr, samples[len(img.Y)+len(img.Cb):])
+	return img, nil
+}
+
+// DecodeTopRows reads a WebP image from r and decodes only its top rows, for example to extract
+// the dominant colors of a strip. The width is taken from the WebP header and dwebp decodes the
+// area with -crop, which avoids decoding the rest of the image.
+// Rows beyond the height of the image are ignored. Animations are not supported.
+//
+// Parameters:
+//   - r: The io.Reader containing the WebP image data
+//   - rows: The number of rows to decode, starting at the top
+//
+// Returns:
+//   - image.Image: The decoded rows
+//   - error: Any error encountered during decoding
+func DecodeTopRows(r io.Reader, rows int) (image.Image, error) {
+	if rows <= 0 {
+		return nil, fmt.Errorf("failed to decode WebP image: invalid number of rows %d", rows)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP image: %w", err)
+	}
+
+	info, err := readWebPInfo(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP header: %w", err)
+	}
+	if info.animated {
+		return nil, errors.New("failed to decode WebP image: animations are not supported")
+	}
+
+	// The data is already in memory, so decoding it again through PAM output is cheap.
+	d := NewDWebP().Input(bytes.NewReader(data)).OnPNGDecodeError(RecoveryRetryRaw)
+	d.crop = &cropInfo{0, 0, info.width, min(rows, info.height)}
+	img, err := d.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode WebP image: %w", err)
+	}
+	return img, nil
+}
+
+// decodeMemoryOverhead is the fixed amount of memory added to decode estimates,
+// covering buffers and bookkeeping of the decode independent of the image size.
+const decodeMemoryOverhead = 64 << 10
+
+// EstimateDecodeMemory estimates the memory in bytes required to decode a WebP image,
+// based on the dimensions declared in its header. The image itself is not decoded.
+// The estimate covers the decoded image with 4 bytes per pixel plus a fixed overhead;
+// it can be used to reject or queue decodes of large images up front.
+//
+// Parameters:
+//   - r: The io.Reader containing the WebP image data
+//
+// Returns:
+//   - int64: The estimated memory required in bytes
+//   - error: Any error encountered while reading the header
+func EstimateDecodeMemory(r io.Reader) (int64, error) {
+	config, err := DecodeConfig(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(config.Width)*int64(config.Height)*4 + decodeMemoryOverhead, nil
+}
+
+// DecodeBatch decodes multiple WebP images concurrently.
+// The results are positionally aligned with the inputs: images[i] and errs[i] belong to readers[i].
+// At most concurrency images are decoded at the same time; if concurrency is less than 1,
+// the number of logical CPUs is used.
+// Once the context is done, decodes that haven't started yet fail with the context error.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - readers: The io.Readers containing the WebP image data
+//   - concurrency: The maximum number of concurrent decodes
+//
+// Returns:
+//   - []image.Image: The decoded images, nil where decoding failed
+//   - []error: The errors encountered for each input, nil where decoding succeeded
+func DecodeBatch(ctx context.Context, readers []io.Reader, concurrency int) ([]image.Image, []error) {
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+
+	images := make([]image.Image, len(readers))
+	errs := make([]error, len(readers))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, r := range readers {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for j := i; j < len(readers); j++ {
+				errs[j] = fmt.Errorf("failed to decode WebP image: %w", ctx.Err())
+			}
+			wg.Wait()
+			return images, errs
+		}
+
+		wg.Add(1)
+		go func(i int, r io.Reader) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			images[i], errs[i] = DecodeWithContext(ctx, r)
+		}(i, r)
+	}
+
+	wg.Wait()
+	return images, errs
+}
@@ -1,7 +1,14 @@
 package webpwrap
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,3 +25,174 @@ func TestDecode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, imgSource.Bounds(), imgTarget.Bounds())
 }
+
+func TestDecodeAndReencodeFallback(t *testing.T) {
+	data, err := os.ReadFile("source.webp")
+	assert.Nil(t, err)
+	decoded, err := Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	assert.Nil(t, DecodeAndReencodeFallback(context.Background(), bytes.NewReader(data), &b))
+	fallback, err := png.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, decoded.Bounds(), fallback.Bounds())
+	bounds := decoded.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 7 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 7 {
+			r1, g1, b1, a1 := decoded.At(x, y).RGBA()
+			r2, g2, b2, a2 := fallback.At(x, y).RGBA()
+			if !assert.Equal(t, [4]uint32{r1, g1, b1, a1}, [4]uint32{r2, g2, b2, a2}) {
+				return
+			}
+		}
+	}
+
+	// The fallback is no larger than a PNG with the default compression.
+	var reference bytes.Buffer
+	assert.Nil(t, png.Encode(&reference, decoded))
+	assert.LessOrEqual(t, b.Len(), reference.Len())
+
+	err = DecodeAndReencodeFallback(context.Background(), strings.NewReader("not a webp"), &b)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeTopRows(t *testing.T) {
+	data, err := os.ReadFile("source.webp")
+	assert.Nil(t, err)
+	full, err := Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	img, err := DecodeTopRows(bytes.NewReader(data), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, full.Bounds().Dx(), img.Bounds().Dx())
+	assert.Equal(t, 10, img.Bounds().Dy())
+	assert.Equal(t, full.At(5, 5), img.At(5, 5))
+
+	img, err = DecodeTopRows(bytes.NewReader(data), full.Bounds().Dy()+100)
+	assert.Nil(t, err)
+	assert.Equal(t, full.Bounds(), img.Bounds())
+
+	_, err = DecodeTopRows(bytes.NewReader(data), 0)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeTopRowsPAMRecovery(t *testing.T) {
+	pngDecode = func(r io.Reader) (image.Image, error) {
+		return nil, errors.New("png: invalid format: chunk out of order")
+	}
+	defer func() { pngDecode = png.Decode }()
+
+	data, err := os.ReadFile("source.webp")
+	assert.Nil(t, err)
+	info, err := readWebPInfo(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	// The crop is kept when the image is decoded again through PAM output.
+	img, err := DecodeTopRows(bytes.NewReader(data), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, info.width, 10), img.Bounds())
+}
+
+func TestDecodeBatch(t *testing.T) {
+	var readers []io.Reader
+	var sizes []image.Rectangle
+	for i := 1; i <= 5; i++ {
+		size := image.Rect(0, 0, 8*i, 4*i)
+		var b bytes.Buffer
+		err := Encode(&b, image.NewNRGBA(size))
+		assert.Nil(t, err)
+		readers = append(readers, &b)
+		sizes = append(sizes, size)
+	}
+	readers[2] = strings.NewReader("not a webp image")
+
+	images, errs := DecodeBatch(context.Background(), readers, 2)
+	assert.Len(t, images, 5)
+	assert.Len(t, errs, 5)
+	for i := range readers {
+		if i == 2 {
+			assert.NotNil(t, errs[i])
+			assert.Nil(t, images[i])
+			continue
+		}
+		assert.Nil(t, errs[i])
+		assert.Equal(t, sizes[i], images[i].Bounds())
+	}
+}
+
+func TestDecodeBatchCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	readers := []io.Reader{strings.NewReader(""), strings.NewReader("")}
+	images, errs := DecodeBatch(ctx, readers, 1)
+	assert.Len(t, images, 2)
+	for _, err := range errs {
+		assert.NotNil(t, err)
+	}
+}
+
+func TestEstimateDecodeMemory(t *testing.T) {
+	f, err := os.Open("source.webp")
+	assert.Nil(t, err)
+	defer f.Close()
+	estimate, err := EstimateDecodeMemory(f)
+	assert.Nil(t, err)
+
+	f.Seek(0, 0)
+	img, err := Decode(f)
+	assert.Nil(t, err)
+
+	var actual int
+	switch i := img.(type) {
+	case *image.NRGBA:
+		actual = len(i.Pix)
+	case *image.RGBA:
+		actual = len(i.Pix)
+	default:
+		t.Fatalf("unexpected image type %T", img)
+	}
+	assert.InDelta(t, actual, estimate, decodeMemoryOverhead)
+	assert.GreaterOrEqual(t, estimate, int64(actual))
+
+	_, err = EstimateDecodeMemory(strings.NewReader("not a webp image"))
+	assert.NotNil(t, err)
+}
+
+func TestDecodeYCbCr(t *testing.T) {
+	data, err := os.ReadFile("source.webp")
+	assert.Nil(t, err)
+	reference, err := webp.Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+	var expected *image.YCbCr
+	switch ref := reference.(type) {
+	case *image.YCbCr:
+		expected = ref
+	case *image.NYCbCrA:
+		expected = &ref.YCbCr
+	default:
+		t.Skip("source.webp is not a lossy image")
+	}
+
+	img, err := DecodeYCbCr(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, expected.Bounds(), img.Bounds())
+	assert.Equal(t, image.YCbCrSubsampleRatio420, img.SubsampleRatio)
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	assert.Equal(t, w, img.YStride)
+	assert.Equal(t, (w+1)/2, img.CStride)
+	assert.Len(t, img.Y, w*h)
+	assert.Len(t, img.Cb, ((w+1)/2)*((h+1)/2))
+	assert.Len(t, img.Cr, ((w+1)/2)*((h+1)/2))
+
+	x, y := w/2, h/2
+	assert.InDelta(t, expected.Y[expected.YOffset(x, y)], img.Y[img.YOffset(x, y)], 1)
+	assert.InDelta(t, expected.Cb[expected.COffset(x, y)], img.Cb[img.COffset(x, y)], 1)
+}
+
+func TestDecodeYCbCrInvalid(t *testing.T) {
+	_, err := DecodeYCbCr(strings.NewReader("not a webp image"))
+	assert.NotNil(t, err)
+}
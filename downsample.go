@@ -0,0 +1,88 @@
+package webpwrap
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"sync"
+)
+
+// linear8 holds the linear light values of the 8-bit sRGB values in ascending order.
+var linear8 = sync.OnceValue(func() [256]float64 {
+	var values [256]float64
+	for i := range values {
+		values[i] = decodeSRGB(float64(i) / 255)
+	}
+	return values
+})
+
+// decodeSRGB removes the sRGB transfer function from a value in [0, 1].
+func decodeSRGB(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// is16Bit reports whether the image has 16 bits per channel.
+func is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// downsampleLinear reduces an image with 16 bits per channel to 8 bits per channel in linear light.
+// Every color is quantized to the 8-bit sRGB value nearest to it in linear light, and the
+// quantization error is diffused to the neighboring pixels (Floyd-Steinberg), so smooth gradients
+// keep their average brightness instead of breaking into bands. The alpha channel is rounded.
+func downsampleLinear(img image.Image) *image.NRGBA {
+	lin := linear8()
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	out := image.NewNRGBA(bounds)
+
+	// The errors diffused into the current and the next row, padded by a pixel on both sides.
+	current, next := make([][3]float64, width+2), make([][3]float64, width+2)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+x, y)).(color.NRGBA64)
+
+			var rgb [3]uint8
+			for i, v := range [3]uint16{c.R, c.G, c.B} {
+				want := decodeSRGB(float64(v)/65535) + current[x+1][i]
+				rgb[i] = nearestLinear(lin, want)
+
+				e := want - lin[rgb[i]]
+				current[x+2][i] += e * 7 / 16
+				next[x][i] += e * 3 / 16
+				next[x+1][i] += e * 5 / 16
+				next[x+2][i] += e / 16
+			}
+
+			a := uint8((uint32(c.A)*255 + 32767) / 65535)
+			out.SetNRGBA(bounds.Min.X+x, y, color.NRGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: a})
+		}
+
+		current, next = next, current
+		clear(next)
+	}
+
+	return out
+}
+
+// nearestLinear returns the 8-bit sRGB value whose linear light value is nearest to v.
+func nearestLinear(lin [256]float64, v float64) uint8 {
+	i := sort.SearchFloat64s(lin[:], v)
+	switch {
+	case i == 0:
+		return 0
+	case i == len(lin):
+		return 255
+	case v-lin[i-1] < lin[i]-v:
+		return uint8(i - 1)
+	}
+	return uint8(i)
+}
@@ -0,0 +1,80 @@
+package webpwrap
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeGammaAwareDownsample(t *testing.T) {
+	// A dark 16-bit gradient spanning only a few 8-bit levels, which truncation turns into bands.
+	img := image.NewNRGBA64(image.Rect(0, 0, 256, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 256; x++ {
+			v := uint16(0x1000 + x*4)
+			img.SetNRGBA64(x, y, color.NRGBA64{R: v, G: v, B: v, A: 0xffff})
+		}
+	}
+
+	// banding returns the mean deviation of 8x8 block averages of the decoded image
+	// from the source in linear light, and the number of distinct gray levels in a row.
+	banding := func(c *CWebP) (float64, int) {
+		var b bytes.Buffer
+		assert.Nil(t, c.Lossless().InputImage(img).Output(&b).Run())
+		decoded, err := NewDWebP().Input(&b).Run()
+		assert.Nil(t, err)
+
+		var deviation float64
+		var blocks int
+		for by := 0; by < 32; by += 8 {
+			for bx := 0; bx < 256; bx += 8 {
+				var want, got float64
+				for y := by; y < by+8; y++ {
+					for x := bx; x < bx+8; x++ {
+						want += decodeSRGB(float64(img.NRGBA64At(x, y).R) / 65535)
+						got += decodeSRGB(float64(color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA).R) / 255)
+					}
+				}
+				deviation += math.Abs(want-got) / 64
+				blocks++
+			}
+		}
+
+		levels := map[uint8]bool{}
+		for x := 0; x < 256; x++ {
+			levels[color.NRGBAModel.Convert(decoded.At(x, 0)).(color.NRGBA).R] = true
+		}
+		return deviation / float64(blocks), len(levels)
+	}
+
+	naive, naiveLevels := banding(NewCWebP())
+	aware, awareLevels := banding(NewCWebP().GammaAwareDownsample(true))
+	assert.Less(t, aware, naive/4)
+	assert.GreaterOrEqual(t, awareLevels, naiveLevels)
+
+	// Images with 8 bits per channel are left unchanged.
+	assert.False(t, is16Bit(image.NewNRGBA(image.Rect(0, 0, 1, 1))))
+}
+
+func TestDownsampleLinear(t *testing.T) {
+	img := image.NewRGBA64(image.Rect(2, 3, 6, 5))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.SetRGBA64(3, 4, color.RGBA64{R: 0x8080, G: 0, B: 0, A: 0x8080})
+
+	out := downsampleLinear(img)
+	assert.Equal(t, img.Bounds(), out.Bounds())
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, out.NRGBAAt(2, 3))
+	// Premultiplied colors are converted to straight alpha.
+	assert.Equal(t, color.NRGBA{R: 255, G: 0, B: 0, A: 128}, out.NRGBAAt(3, 4))
+
+	// Exact 8-bit values survive the conversion.
+	for _, v := range []uint8{0, 1, 17, 128, 254, 255} {
+		assert.Equal(t, v, nearestLinear(linear8(), decodeSRGB(float64(v)/255)))
+	}
+}
@@ -11,19 +11,59 @@ import (
 	"image"
 	"image/png"
 	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/belphemur/go-binwrapper"
 )
 
+// RecoveryMode selects how DWebP handles PNG output of dwebp that fails to decode.
+type RecoveryMode int
+
+const (
+	// RecoveryFailFast returns the decode error right away, which is the default.
+	RecoveryFailFast RecoveryMode = iota
+	// RecoveryRetryRaw runs dwebp a second time with PAM output and builds the image
+	// from the raw RGBA samples.
+	RecoveryRetryRaw
+	// RecoveryReturnRawBytes returns the decode error and keeps the PNG output of dwebp,
+	// which is available through RawOutput.
+	RecoveryReturnRawBytes
+)
+
+// String returns the name of the recovery mode.
+func (m RecoveryMode) String() string {
+	switch m {
+	case RecoveryFailFast:
+		return "fail-fast"
+	case RecoveryRetryRaw:
+		return "retry-raw"
+	case RecoveryReturnRawBytes:
+		return "return-raw-bytes"
+	}
+	return fmt.Sprintf("RecoveryMode(%d)", int(m))
+}
+
 // DWebP wraps the dwebp command-line tool for decompressing WebP files into PNG format.
 // It provides various options for input/output handling and supports both file and stream-based operations.
 // For more information, see: https://developers.google.com/speed/webp/docs/dwebp
 type DWebP struct {
 	*binwrapper.BinWrapper
+	options    []string  // Names of the options passed to NewDWebP
 	inputFile  string    // Path to the input WebP file
 	input      io.Reader // Input as io.Reader
 	outputFile string    // Path to the output PNG file
 	output     io.Writer // Output as io.Writer
+	honorICC   bool      // Convert the decoded image to sRGB using the embedded ICC profile
+	yuv        bool      // Write raw planar Y'CbCr 4:2:0 samples instead of PNG
+	strictDims bool      // Check the decoded dimensions against the header
+	crop       *cropInfo // Area to decode, nil for the whole image
+
+	pngRecovery RecoveryMode        // Handling of PNG output that fails to decode
+	rawOutput   []byte              // PNG output of the last run that failed to decode
+	minVersion  *versionRequirement // Minimum binary version set with RequireMinVersion, nil if unset
 }
 
 // NewDWebP creates a new DWebP instance with the given options.
@@ -31,11 +71,19 @@ type DWebP struct {
 func NewDWebP(optionFuncs ...OptionFunc) *DWebP {
 	bin := &DWebP{
 		BinWrapper: createBinWrapper(optionFuncs...),
+		options:    optionNames(optionFuncs),
+		minVersion: newVersionRequirement(optionFuncs),
 	}
 	bin.ExecPath("dwebp")
 	return bin
 }
 
+// AppliedOptions returns the names of the options passed to NewDWebP in the order they were applied,
+// such as "WithVendorPath", for debugging the configuration of the instance.
+func (c *DWebP) AppliedOptions() []string {
+	return slices.Clone(c.options)
+}
+
 // InputFile sets the WebP file to convert.
 // Any previous calls to Input will be ignored.
 // Returns the DWebP instance for method chaining.
@@ -72,12 +120,61 @@ func (c *DWebP) Output(writer io.Writer) *DWebP {
 	return c
 }
 
+// HonorICC controls whether the embedded ICC profile is applied to the decoded image.
+// When enabled, the pixels of the image returned by Run are converted from the color space
+// described by the ICCP chunk to sRGB. Images without an embedded profile are returned unchanged.
+// Only matrix/TRC RGB profiles (such as Display P3 or Adobe RGB) are supported;
+// Run returns an error for other profiles.
+// The option has no effect when an output file or writer is set.
+// The default is false, which returns the raw decoded values.
+// Returns the DWebP instance for method chaining.
+func (c *DWebP) HonorICC(honor bool) *DWebP {
+	c.honorICC = honor
+	return c
+}
+
+// StrictDimensions enables checking the dimensions of the decoded image against the
+// dimensions declared in the WebP header. If they differ, Run returns ErrDimensionMismatch
+// instead of the image. This guards against files whose header understates the image size
+// to slip past size limits based on DecodeConfig or EstimateDecodeMemory.
+// The check only applies when the decoded image is returned by Run.
+// Returns the DWebP instance for method chaining.
+func (c *DWebP) StrictDimensions() *DWebP {
+	c.strictDims = true
+	return c
+}
+
+// OnPNGDecodeError sets how Run handles PNG output of dwebp that image/png fails to decode,
+// which happens for valid but unusual PNG variants written by some dwebp builds.
+// By default, RecoveryFailFast returns the error. RecoveryRetryRaw decodes the input again
+// through PAM output instead, which buffers streamed input to read it a second time, and
+// RecoveryReturnRawBytes keeps the PNG output for RawOutput, so callers can decode it themselves. In every mode, a failure is reported with
+// an error wrapping ErrPNGDecode.
+// The setting only applies when the decoded image is returned by Run.
+// Returns the DWebP instance for method chaining.
+func (c *DWebP) OnPNGDecodeError(mode RecoveryMode) *DWebP {
+	c.pngRecovery = mode
+	return c
+}
+
+// RawOutput returns the PNG output of dwebp that failed to decode in the last run
+// with RecoveryReturnRawBytes. Returns nil otherwise.
+func (c *DWebP) RawOutput() []byte {
+	return c.rawOutput
+}
+
 // Version returns the version of the dwebp binary.
 // Returns the version string and any error encountered.
 func (c *DWebP) Version() (string, error) {
 	return version(c.BinWrapper)
 }
 
+// VersionInfo returns the parsed version of the dwebp binary.
+// Returns the version and any error encountered.
+func (c *DWebP) VersionInfo() (VersionInfo, error) {
+	return versionInfo(c.BinWrapper)
+}
+
 // Run executes the dwebp command with the specified parameters.
 // Returns the decoded image and any error encountered during the process.
 // If no output is specified, returns the decoded image as an image.Image.
@@ -91,59 +188,229 @@ func (c *DWebP) Run() (image.Image, error) {
 // Returns the decoded image and any error encountered during the process.
 // If no output is specified, returns the decoded image as an image.Image.
 // If an output is specified (file or writer), returns nil, nil.
+//
+// When returning an image, the PNG produced by dwebp is decoded with image/png.
+// The decode is bounded by the context as well and stops once the context is done.
+// If that decode fails, dwebp is run a second time with PAM output (-pam) and
+// the image is constructed from the raw RGBA samples instead.
 func (c *DWebP) RunWithContext(ctx context.Context) (image.Image, error) {
 	defer c.BinWrapper.Reset()
 
+	c.rawOutput = nil
+
+	if err := c.minVersion.check(c.BinWrapper, "dwebp"); err != nil {
+		return nil, err
+	}
+
+	args := c.decodeArgs()
+	for _, arg := range args {
+		c.Arg(arg)
+	}
+
 	output, err := c.getOutput()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get output: %w", err)
 	}
 
+	if c.yuv {
+		c.Arg("-yuv")
+	}
+
 	c.Arg("-o", output)
 
-	if err := c.setInput(); err != nil {
+	// Keep a copy of streamed input if it is read again, such as by the PAM recovery path.
+	var inputCopy *bytes.Buffer
+	input := c.input
+	if input != nil && c.replaysInput() {
+		inputCopy = &bytes.Buffer{}
+		input = io.TeeReader(input, inputCopy)
+	}
+
+	if err := c.setInput(input); err != nil {
 		return nil, fmt.Errorf("failed to set input: %w", err)
 	}
 
+	var ow *outputWriter
 	if c.output != nil {
-		c.SetStdOut(c.output)
+		ow = &outputWriter{w: c.output}
+	}
+
+	stdout, _, err := runBinary(ctx, c.BinWrapper, "dwebp", input, ow)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create a channel to handle context cancellation
-	done := make(chan struct{})
-	go func() {
-		<-ctx.Done()
-		c.BinWrapper.Kill()
-		close(done)
-	}()
+	if !c.returnsImage() {
+		return nil, nil
+	}
 
-	err = c.BinWrapper.Run()
+	// source returns the buffered input, or nil if the input file is to be read again.
+	source := func() io.Reader {
+		if inputCopy != nil {
+			return bytes.NewReader(inputCopy.Bytes())
+		}
+		return nil
+	}
+
+	img, err := pngDecode(&contextReader{ctx: ctx, r: bytes.NewReader(stdout)})
 	if err != nil {
-		select {
-		case <-done:
-			return nil, fmt.Errorf("operation cancelled: %w", ctx.Err())
-		default:
-			return nil, fmt.Errorf("dwebp command failed: %w. stderr: %s", err, c.StdErr())
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, contextError(ctxErr)
+		}
+		switch c.pngRecovery {
+		case RecoveryFailFast:
+			return nil, fmt.Errorf("%w: %w", ErrPNGDecode, err)
+		case RecoveryReturnRawBytes:
+			c.rawOutput = stdout
+			return nil, fmt.Errorf("%w: %w", ErrPNGDecode, err)
+		}
+
+		var pamErr error
+		img, pamErr = c.decodePAM(ctx, args, source())
+		if pamErr != nil {
+			return nil, fmt.Errorf("%w: %w (PAM recovery failed: %v)", ErrPNGDecode, err, pamErr)
+		}
+	}
+
+	if c.strictDims {
+		if err := c.checkDimensions(img, source()); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.honorICC {
+		img, err = c.applyICC(img, source())
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply ICC profile: %w", err)
+		}
+	}
+
+	return img, nil
+}
+
+// decodeArgs returns the arguments controlling how the image is decoded,
+// which are passed to both the PNG and the PAM run of dwebp.
+func (c *DWebP) decodeArgs() []string {
+	if c.crop == nil {
+		return nil
+	}
+	return []string{"-crop", strconv.Itoa(c.crop.x), strconv.Itoa(c.crop.y),
+		strconv.Itoa(c.crop.width), strconv.Itoa(c.crop.height)}
+}
+
+// pngDecode decodes the PNG output of dwebp. It is replaceable for testing.
+var pngDecode = png.Decode
+
+// contextReader fails reads once the context is done, which stops a decoder reading from it.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// returnsImage reports whether the decoded image is returned by Run instead of being written to an output.
+func (c *DWebP) returnsImage() bool {
+	return c.output == nil && c.outputFile == ""
+}
+
+// replaysInput reports whether the input may be read again after dwebp ran, which is the case when
+// the image is returned and either the PAM recovery path, StrictDimensions or HonorICC may need it.
+func (c *DWebP) replaysInput() bool {
+	if !c.returnsImage() {
+		return false
+	}
+	retries := c.pngRecovery != RecoveryFailFast && c.pngRecovery != RecoveryReturnRawBytes
+	return retries || c.strictDims || c.honorICC
+}
+
+// checkDimensions compares the bounds of the decoded image with the dimensions declared in the input header.
+// The input is either the given reader containing the buffered input or, if nil, the input file.
+func (c *DWebP) checkDimensions(img image.Image, input io.Reader) error {
+	if input == nil {
+		f, err := os.Open(c.inputFile)
+		if err != nil {
+			return err
 		}
+		defer f.Close()
+		input = f
+	}
+
+	info, err := readWebPInfo(input)
+	if err != nil {
+		return fmt.Errorf("failed to read WebP header: %w", err)
 	}
 
-	if c.output == nil && c.outputFile == "" {
-		img, err := png.Decode(bytes.NewReader(c.BinWrapper.StdOut()))
+	bounds := img.Bounds()
+	if bounds.Dx() != info.width || bounds.Dy() != info.height {
+		return fmt.Errorf("%w: header declares %dx%d, decoded image is %dx%d",
+			ErrDimensionMismatch, info.width, info.height, bounds.Dx(), bounds.Dy())
+	}
+	return nil
+}
+
+// applyICC converts the decoded image to sRGB using the ICC profile embedded in the input.
+// The input is either the given reader containing the buffered input or, if nil, the input file.
+func (c *DWebP) applyICC(img image.Image, input io.Reader) (image.Image, error) {
+	if input == nil {
+		f, err := os.Open(c.inputFile)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode PNG output: %w", err)
+			return nil, err
 		}
+		defer f.Close()
+		input = f
+	}
+
+	profile, err := readICCProfile(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == nil {
 		return img, nil
 	}
 
-	return nil, nil
+	t, err := newICCTransform(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.apply(img), nil
+}
+
+// decodePAM runs dwebp again with PAM output and constructs the image from the raw samples.
+// The decode arguments are those of the PNG run, so the image is decoded with the same settings.
+// The input is either the given reader containing the buffered input or, if nil, the input file.
+func (c *DWebP) decodePAM(ctx context.Context, args []string, input io.Reader) (image.Image, error) {
+	c.BinWrapper.Reset()
+	for _, arg := range args {
+		c.Arg(arg)
+	}
+	c.Arg("-pam", "-o", "-")
+
+	if err := c.setInput(input); err != nil {
+		return nil, fmt.Errorf("failed to set input: %w", err)
+	}
+
+	stdout, _, err := runBinary(ctx, c.BinWrapper, "dwebp", input, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePAM(stdout)
 }
 
 // setInput configures the input source for the dwebp command.
-// Returns an error if no input source is defined.
-func (c *DWebP) setInput() error {
-	if c.input != nil {
+// The given reader takes the place of the reader set with Input and is passed to dwebp
+// as its standard input. Returns an error if no input source is defined.
+func (c *DWebP) setInput(input io.Reader) error {
+	if input != nil {
 		c.Arg("--").Arg("-")
-		c.StdIn(c.input)
 	} else if c.inputFile != "" {
 		c.Arg(c.inputFile)
 	} else {
@@ -160,3 +427,83 @@ func (c *DWebP) getOutput() (string, error) {
 	}
 	return "-", nil
 }
+
+// decodePAM constructs an image from PAM data as written by dwebp -pam.
+// Supports 8-bit RGB_ALPHA, RGB, GRAYSCALE and GRAYSCALE_ALPHA tuple types.
+func decodePAM(data []byte) (image.Image, error) {
+	var width, height, depth, maxval int
+	tupleType := ""
+
+	if !bytes.HasPrefix(data, []byte("P7\n")) {
+		return nil, errors.New("invalid PAM header")
+	}
+	data = data[3:]
+
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return nil, errors.New("truncated PAM header")
+		}
+		line := strings.TrimSpace(string(data[:i]))
+		data = data[i+1:]
+
+		if line == "ENDHDR" {
+			break
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid PAM header line: %q", line)
+		}
+
+		var err error
+		switch fields[0] {
+		case "WIDTH":
+			width, err = strconv.Atoi(fields[1])
+		case "HEIGHT":
+			height, err = strconv.Atoi(fields[1])
+		case "DEPTH":
+			depth, err = strconv.Atoi(fields[1])
+		case "MAXVAL":
+			maxval, err = strconv.Atoi(fields[1])
+		case "TUPLTYPE":
+			tupleType = fields[1]
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid PAM header line: %q", line)
+		}
+	}
+
+	if width <= 0 || height <= 0 || maxval != 255 {
+		return nil, fmt.Errorf("unsupported PAM image: %dx%d, maxval %d", width, height, maxval)
+	}
+
+	expectedDepth := map[string]int{"RGB_ALPHA": 4, "RGB": 3, "GRAYSCALE_ALPHA": 2, "GRAYSCALE": 1}[tupleType]
+	if expectedDepth == 0 || depth != expectedDepth {
+		return nil, fmt.Errorf("unsupported PAM tuple type %q with depth %d", tupleType, depth)
+	}
+
+	if len(data) < width*height*depth {
+		return nil, errors.New("truncated PAM data")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		px := data[i*depth : (i+1)*depth]
+		switch depth {
+		case 4:
+			copy(img.Pix[i*4:], px)
+		case 3:
+			img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = px[0], px[1], px[2], 255
+		case 2:
+			img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = px[0], px[0], px[0], px[1]
+		case 1:
+			img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = px[0], px[0], px[0], 255
+		}
+	}
+
+	return img, nil
+}
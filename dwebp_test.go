@@ -1,9 +1,18 @@
 package webpwrap
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
 	"image/png"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/image/webp"
@@ -18,6 +27,38 @@ func TestVersionDWebP(t *testing.T) {
 	}
 }
 
+func TestVersionInfoDWebP(t *testing.T) {
+	c := NewDWebP()
+	v, err := c.VersionInfo()
+	assert.Nil(t, err)
+	if _, ok := os.LookupEnv("DOCKER_ARM_TEST"); !ok {
+		assert.Equal(t, VersionInfo{1, 5, 0}, v)
+	}
+}
+
+func TestParseVersionInfo(t *testing.T) {
+	v, err := ParseVersionInfo("1.5.0libsharpyuv: 0.4.1")
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{1, 5, 0}, v)
+	assert.Equal(t, "1.5.0", v.String())
+	assert.True(t, v.AtLeast(1, 5, 0))
+	assert.True(t, v.AtLeast(1, 4, 9))
+	assert.True(t, v.AtLeast(0, 6, 1))
+	assert.False(t, v.AtLeast(1, 5, 1))
+	assert.False(t, v.AtLeast(2, 0, 0))
+
+	_, err = ParseVersionInfo("unknown")
+	assert.NotNil(t, err)
+}
+
+func TestDecodeArgs(t *testing.T) {
+	assert.Empty(t, NewDWebP().decodeArgs())
+
+	c := NewDWebP()
+	c.crop = &cropInfo{x: 0, y: 0, width: 16, height: 8}
+	assert.Equal(t, []string{"-crop", "0", "0", "16", "8"}, c.decodeArgs())
+}
+
 func TestDecodeReader(t *testing.T) {
 	c := NewDWebP()
 	f, err := os.Open("source.webp")
@@ -56,6 +97,157 @@ func TestDecodeImage(t *testing.T) {
 	assert.Equal(t, imgSource.Bounds(), imgTarget.Bounds())
 }
 
+func TestDecodeImagePAMRecovery(t *testing.T) {
+	pngDecode = func(r io.Reader) (image.Image, error) {
+		return nil, errors.New("png: invalid format: chunk out of order")
+	}
+	defer func() { pngDecode = png.Decode }()
+
+	f, err := os.Open("source.webp")
+	assert.Nil(t, err)
+	defer f.Close()
+	imgSource, err := webp.Decode(f)
+	assert.Nil(t, err)
+	f.Seek(0, 0)
+
+	imgTarget, err := NewDWebP().Input(f).OnPNGDecodeError(RecoveryRetryRaw).Run()
+	assert.Nil(t, err)
+	assert.NotNil(t, imgTarget)
+	assert.Equal(t, imgSource.Bounds(), imgTarget.Bounds())
+
+	imgTarget, err = NewDWebP().InputFile("source.webp").OnPNGDecodeError(RecoveryRetryRaw).Run()
+	assert.Nil(t, err)
+	assert.NotNil(t, imgTarget)
+	assert.Equal(t, imgSource.Bounds(), imgTarget.Bounds())
+}
+
+func TestDecodeOnPNGDecodeError(t *testing.T) {
+	var decoded []byte
+	pngDecode = func(r io.Reader) (image.Image, error) {
+		decoded, _ = io.ReadAll(r)
+		return nil, errors.New("png: invalid format: chunk out of order")
+	}
+	defer func() { pngDecode = png.Decode }()
+
+	imgSource, err := webp.Decode(mustOpen(t, "source.webp"))
+	assert.Nil(t, err)
+
+	// The default mode fails fast, like an explicit RecoveryFailFast.
+	for _, c := range []*DWebP{NewDWebP(), NewDWebP().OnPNGDecodeError(RecoveryFailFast)} {
+		img, err := c.InputFile("source.webp").Run()
+		assert.Nil(t, img)
+		assert.ErrorIs(t, err, ErrPNGDecode)
+		assert.ErrorContains(t, err, "chunk out of order")
+		assert.Nil(t, c.RawOutput())
+	}
+
+	c := NewDWebP().InputFile("source.webp").OnPNGDecodeError(RecoveryRetryRaw)
+	img, err := c.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, imgSource.Bounds(), img.Bounds())
+	assert.Nil(t, c.RawOutput())
+
+	c = NewDWebP().Input(mustOpen(t, "source.webp")).OnPNGDecodeError(RecoveryReturnRawBytes)
+	img, err = c.Run()
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrPNGDecode)
+	assert.Equal(t, decoded, c.RawOutput())
+	raw, err := png.Decode(bytes.NewReader(c.RawOutput()))
+	assert.Nil(t, err)
+	assert.Equal(t, imgSource.Bounds(), raw.Bounds())
+
+	// The raw output is dropped by the next run.
+	pngDecode = png.Decode
+	img, err = c.InputFile("source.webp").Run()
+	assert.Nil(t, err)
+	assert.NotNil(t, img)
+	assert.Nil(t, c.RawOutput())
+}
+
+func TestDecodeReplaysInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(c *DWebP)
+		replays bool
+	}{
+		{"default", func(c *DWebP) {}, false},
+		{"retry raw", func(c *DWebP) { c.OnPNGDecodeError(RecoveryRetryRaw) }, true},
+		{"fail fast", func(c *DWebP) { c.OnPNGDecodeError(RecoveryFailFast) }, false},
+		{"return raw bytes", func(c *DWebP) { c.OnPNGDecodeError(RecoveryReturnRawBytes) }, false},
+		{"fail fast strict", func(c *DWebP) { c.OnPNGDecodeError(RecoveryFailFast).StrictDimensions() }, true},
+		{"fail fast ICC", func(c *DWebP) { c.OnPNGDecodeError(RecoveryFailFast).HonorICC(true) }, true},
+		{"output", func(c *DWebP) { c.Output(io.Discard) }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewDWebP()
+			tt.set(c)
+			assert.Equal(t, tt.replays, c.replaysInput())
+		})
+	}
+}
+
+func TestDecodePAM(t *testing.T) {
+	data := []byte("P7\nWIDTH 2\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n" +
+		"\x01\x02\x03\x04\x05\x06\x07\x08")
+	img, err := decodePAM(data)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), img.Bounds())
+	assert.Equal(t, color.NRGBA{1, 2, 3, 4}, img.At(0, 0))
+	assert.Equal(t, color.NRGBA{5, 6, 7, 8}, img.At(1, 0))
+
+	data = []byte("P7\nWIDTH 1\nHEIGHT 1\nDEPTH 3\nMAXVAL 255\nTUPLTYPE RGB\nENDHDR\n\x01\x02\x03")
+	img, err = decodePAM(data)
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{1, 2, 3, 255}, img.At(0, 0))
+
+	_, err = decodePAM([]byte("P7\nWIDTH 2\nHEIGHT 2\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n\x01"))
+	assert.NotNil(t, err)
+	_, err = decodePAM([]byte("P6\n1 1\n255\n\x01\x02\x03"))
+	assert.NotNil(t, err)
+}
+
+func TestDecodeHonorICC(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+
+	var encoded bytes.Buffer
+	err := NewCWebP().Lossless().InputImage(img).Output(&encoded).Run()
+	assert.Nil(t, err)
+
+	var bitstream []byte
+	err = walkChunks(bytes.NewReader(encoded.Bytes()), func(fourCC string, size uint32, payload io.Reader) error {
+		if fourCC == "VP8L" {
+			bitstream, err = io.ReadAll(payload)
+			return err
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	tagged := buildRIFF(
+		vp8xChunk(vp8xFlagICC, 8, 8),
+		testChunk{"ICCP", buildMatrixTRCProfile(displayP3Primaries)},
+		testChunk{"VP8L", bitstream},
+	)
+
+	raw, err := NewDWebP().Input(bytes.NewReader(tagged)).Run()
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBAModel.Convert(color.NRGBA{R: 200, G: 100, B: 50, A: 255}),
+		color.NRGBAModel.Convert(raw.At(4, 4)))
+
+	honored, err := NewDWebP().HonorICC(true).Input(bytes.NewReader(tagged)).Run()
+	assert.Nil(t, err)
+	px := color.NRGBAModel.Convert(honored.At(4, 4)).(color.NRGBA)
+	assert.Greater(t, px.R, uint8(200))
+	assert.Less(t, px.B, uint8(50))
+}
+
 func TestDecodeWriter(t *testing.T) {
 	f, err := os.Create("target.png")
 	assert.Nil(t, err)
@@ -70,6 +262,122 @@ func TestDecodeWriter(t *testing.T) {
 	validatePng(t)
 }
 
+func TestDecodeWriterError(t *testing.T) {
+	c := NewDWebP()
+	c.InputFile("source.webp")
+	c.Output(&failingWriter{limit: 100})
+	img, err := c.Run()
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrOutputWrite)
+	assert.ErrorIs(t, err, errWriterClosed)
+}
+
+func TestDecodeStrictDimensions(t *testing.T) {
+	img, err := NewDWebP().InputFile("source.webp").StrictDimensions().Run()
+	assert.Nil(t, err)
+	assert.NotNil(t, img)
+
+	// A header understating the canvas size is rejected either by dwebp or by the check.
+	crafted := buildRIFF(vp8xChunk(0, 1, 1), readSourceBitstream(t))
+	img, err = NewDWebP().Input(bytes.NewReader(crafted)).StrictDimensions().Run()
+	assert.Nil(t, img)
+	assert.NotNil(t, err)
+
+	// The decoded image is larger than declared by the header.
+	pngDecode = func(r io.Reader) (image.Image, error) {
+		img, err := png.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx()*10, img.Bounds().Dy()*10)), nil
+	}
+	defer func() { pngDecode = png.Decode }()
+
+	data, err := os.ReadFile("source.webp")
+	assert.Nil(t, err)
+	img, err = NewDWebP().Input(bytes.NewReader(data)).StrictDimensions().Run()
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrDimensionMismatch)
+
+	img, err = NewDWebP().InputFile("source.webp").StrictDimensions().Run()
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrDimensionMismatch)
+
+	// Without the option the mismatch is not detected.
+	img, err = NewDWebP().InputFile("source.webp").Run()
+	assert.Nil(t, err)
+	assert.NotNil(t, img)
+}
+
+func TestDecodeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := NewDWebP()
+	c.InputFile("source.webp")
+	img, err := c.RunWithContext(ctx)
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrCanceled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDecodeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	c := NewDWebP()
+	c.InputFile("source.webp")
+	c.Output(&blockingWriter{ctx})
+	img, err := c.RunWithContext(ctx)
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrCanceled)
+}
+
+func TestDecodeTimeoutMidDecode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binary is a shell script")
+	}
+
+	// The binary hangs until it is killed.
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "dwebp"), []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	c := NewDWebP(WithVendorPath(dir))
+	c.InputFile("source.webp")
+	start := time.Now()
+	img, err := c.RunWithContext(ctx)
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestDecodeCanceledDuringPNGDecode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var decodeErr error
+	pngDecode = func(r io.Reader) (image.Image, error) {
+		// dwebp has finished, the context is canceled right as decoding starts.
+		cancel()
+		var img image.Image
+		img, decodeErr = png.Decode(r)
+		return img, decodeErr
+	}
+	defer func() { pngDecode = png.Decode }()
+
+	img, err := NewDWebP().InputFile("source.webp").RunWithContext(ctx)
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrCanceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, decodeErr, context.Canceled)
+}
+
 func validatePng(t *testing.T) {
 	defer os.Remove("target.png")
 	fSource, err := os.Open("source.webp")
@@ -4,12 +4,19 @@
 package webpwrap
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"fmt"
 	"image"
 	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
 )
 
-// Encoder encodes image.Image into WebP format using cwebp.
+// Encoder encodes image.Image into WebP format using its backend, which runs cwebp by default.
 // It provides control over the encoding quality and other parameters.
 type Encoder struct {
 	// Quality specifies the compression factor for RGB channels.
@@ -18,6 +25,34 @@ type Encoder struct {
 	// - A value of 100 achieves the best quality
 	// - The default is 75
 	Quality uint
+
+	// Lossless enables lossless compression.
+	// In lossless mode Quality controls the compression effort instead of the visual quality.
+	Lossless bool
+
+	// Backend encodes the image, for example the cgo backend returned by NewCgoBackend.
+	// If nil, the default backend set with SetBackend is used.
+	Backend Backend
+}
+
+// Result describes the outcome of an encode operation.
+type Result struct {
+	BytesWritten int64         // Number of bytes written to the output
+	Quality      uint          // Compression quality used for the encode
+	Lossless     bool          // Whether lossless compression was used
+	Duration     time.Duration // Time spent encoding
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
 }
 
 // Encode writes the Image m to w in WebP format.
@@ -45,11 +80,58 @@ func (e *Encoder) Encode(w io.Writer, m image.Image) error {
 // Returns:
 //   - error: Any error encountered during encoding
 func (e *Encoder) EncodeWithContext(ctx context.Context, w io.Writer, m image.Image) error {
-	return NewCWebP().
-		Quality(e.Quality).
-		InputImage(m).
-		Output(w).
-		RunWithContext(ctx)
+	_, err := e.EncodeResultWithContext(ctx, w, m)
+	return err
+}
+
+// EncodeResult writes the Image m to w in WebP format and reports details about the encode.
+// Any Image type may be encoded.
+//
+// Parameters:
+//   - w: The io.Writer to write the encoded WebP data
+//   - m: The image.Image to encode
+//
+// Returns:
+//   - *Result: Details about the encode, such as the number of bytes written
+//   - error: Any error encountered during encoding
+func (e *Encoder) EncodeResult(w io.Writer, m image.Image) (*Result, error) {
+	return e.EncodeResultWithContext(context.Background(), w, m)
+}
+
+// EncodeResultWithContext writes the Image m to w in WebP format with context support
+// and reports details about the encode.
+// The context can be used to cancel the operation.
+// Any Image type may be encoded.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - w: The io.Writer to write the encoded WebP data
+//   - m: The image.Image to encode
+//
+// Returns:
+//   - *Result: Details about the encode, such as the number of bytes written
+//   - error: Any error encountered during encoding
+func (e *Encoder) EncodeResultWithContext(ctx context.Context, w io.Writer, m image.Image) (*Result, error) {
+	if err := checkImageSize(m); err != nil {
+		return nil, err
+	}
+
+	cw := &countingWriter{w: w}
+	start := time.Now()
+	b := e.Backend
+	if b == nil {
+		b = backend()
+	}
+	if err := b.Encode(ctx, cw, m, e); err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		BytesWritten: cw.n,
+		Quality:      min(e.Quality, 100),
+		Lossless:     e.Lossless,
+		Duration:     time.Since(start),
+	}, nil
 }
 
 // Encode writes the Image m to w in WebP format using default settings.
@@ -82,3 +164,84 @@ func EncodeWithContext(ctx context.Context, w io.Writer, m image.Image) error {
 	e := &Encoder{Quality: 75}
 	return e.EncodeWithContext(ctx, w, m)
 }
+
+// EncodeBytes encodes the Image m in WebP format and returns the encoded data.
+// If opts is nil, the default settings of Encode are used.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - m: The image.Image to encode
+//   - opts: The encoder options, or nil for the defaults
+//
+// Returns:
+//   - []byte: The WebP data
+//   - error: Any error encountered during encoding
+func EncodeBytes(ctx context.Context, m image.Image, opts *Encoder) ([]byte, error) {
+	if opts == nil {
+		opts = &Encoder{Quality: 75}
+	}
+
+	var b bytes.Buffer
+	if err := opts.EncodeWithContext(ctx, &b, m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// EncodeDataURI encodes the Image m in WebP format and returns it as a base64 data URI
+// such as "data:image/webp;base64,...", for inlining small images into HTML or CSS.
+// Data URIs are a third larger than the WebP data, so they suit small images only.
+// If opts is nil, the default settings of Encode are used.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - m: The image.Image to encode
+//   - opts: The encoder options, or nil for the defaults
+//
+// Returns:
+//   - string: The data URI of the WebP data
+//   - error: Any error encountered during encoding
+func EncodeDataURI(ctx context.Context, m image.Image, opts *Encoder) (string, error) {
+	data, err := EncodeBytes(ctx, m, opts)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/webp;base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// multipartQuoteEscaper escapes quotes and backslashes in Content-Disposition parameters.
+var multipartQuoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// EncodeToMultipart encodes the Image m into a new form file part of the multipart writer.
+// The part is created with the given field name and file name and the content type image/webp.
+// The WebP data is streamed into the part as cwebp produces it, without buffering the whole image.
+// If opts is nil, the default settings of Encode are used.
+// If encoding fails after the part was created, the multipart message is incomplete and should be discarded.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - mw: The multipart.Writer to create the form file part in
+//   - fieldname: The name of the form field
+//   - filename: The file name reported for the form file
+//   - m: The image.Image to encode
+//   - opts: The encoder options, or nil for the defaults
+//
+// Returns:
+//   - error: Any error encountered while creating the part or during encoding
+func EncodeToMultipart(ctx context.Context, mw *multipart.Writer, fieldname, filename string, m image.Image, opts *Encoder) error {
+	if opts == nil {
+		opts = &Encoder{Quality: 75}
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		multipartQuoteEscaper.Replace(fieldname), multipartQuoteEscaper.Replace(filename)))
+	h.Set("Content-Type", "image/webp")
+
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return fmt.Errorf("failed to create form file part: %w", err)
+	}
+
+	return opts.EncodeWithContext(ctx, part, m)
+}
@@ -2,8 +2,14 @@ package webpwrap
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
 	"image/jpeg"
+	"io"
+	"mime/multipart"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,3 +28,91 @@ func TestEncode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, imgSource.Bounds(), imgTarget.Bounds())
 }
+
+func TestEncodeEmptyImage(t *testing.T) {
+	recorder := &recordingBackend{}
+	SetBackend(recorder)
+	defer SetBackend(nil)
+
+	for _, rect := range []image.Rectangle{image.Rect(0, 0, 0, 0), image.Rect(0, 0, 1, 0)} {
+		var b bytes.Buffer
+		err := Encode(&b, image.NewNRGBA(rect))
+		assert.ErrorIs(t, err, ErrEmptyImage)
+		assert.Zero(t, b.Len())
+
+		// CWebP doesn't use the backend, so the binary would be invoked without the check.
+		err = NewCWebP().InputImage(image.NewNRGBA(rect)).Output(&b).Run()
+		assert.ErrorIs(t, err, ErrEmptyImage)
+	}
+	assert.Zero(t, recorder.encodes)
+}
+
+func TestEncodeResult(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	assert.Nil(t, err)
+	imgSource, err := jpeg.Decode(f)
+	assert.Nil(t, err)
+	var b bytes.Buffer
+	e := &Encoder{Quality: 80}
+	r, err := e.EncodeResult(&b, imgSource)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(b.Len()), r.BytesWritten)
+	assert.Equal(t, uint(80), r.Quality)
+	assert.False(t, r.Lossless)
+	assert.True(t, r.Duration > 0)
+	imgTarget, err := webp.Decode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, imgSource.Bounds(), imgTarget.Bounds())
+}
+
+func TestEncodeToDataURI(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 24, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	uri, err := EncodeDataURI(context.Background(), img, nil)
+	assert.Nil(t, err)
+	payload, ok := strings.CutPrefix(uri, "data:image/webp;base64,")
+	assert.True(t, ok)
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	assert.Nil(t, err)
+	decoded, err := webp.Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), decoded.Bounds())
+
+	_, err = EncodeDataURI(context.Background(), image.NewNRGBA(image.Rect(0, 0, 0, 0)), &Encoder{Lossless: true})
+	assert.ErrorIs(t, err, ErrEmptyImage)
+}
+
+func TestEncodeToMultipart(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	assert.Nil(t, mw.WriteField("title", "example"))
+	err := EncodeToMultipart(context.Background(), mw, "image", "example.webp", img, &Encoder{Quality: 80})
+	assert.Nil(t, err)
+	assert.Nil(t, mw.Close())
+
+	r := multipart.NewReader(&b, mw.Boundary())
+	part, err := r.NextPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "title", part.FormName())
+
+	part, err = r.NextPart()
+	assert.Nil(t, err)
+	assert.Equal(t, "image", part.FormName())
+	assert.Equal(t, "example.webp", part.FileName())
+	assert.Equal(t, "image/webp", part.Header.Get("Content-Type"))
+
+	data, err := io.ReadAll(part)
+	assert.Nil(t, err)
+	imgTarget, err := webp.Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, img.Bounds(), imgTarget.Bounds())
+
+	_, err = r.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
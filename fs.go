@@ -0,0 +1,48 @@
+package webpwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WritableFS is a filesystem that files can be created in, such as an in-memory filesystem for tests.
+// Create creates or truncates the named file and returns it for writing.
+type WritableFS interface {
+	Create(name string) (io.WriteCloser, error)
+}
+
+// SetOutputFS sets the filesystem that output files set with OutputFile are created in,
+// instead of the disk. The output of cwebp is streamed into the file, which is closed after
+// the run. A failed run may leave a partially written file behind. A nil filesystem restores
+// the default. SkipIfLarger and SkipIfUnchanged need the output on disk and can't be combined
+// with an output filesystem; Run returns an error in that case.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) SetOutputFS(fs WritableFS) *CWebP {
+	c.outputFS = fs
+	return c
+}
+
+// runToFS creates the output file in the output filesystem and runs cwebp with the file as its output writer.
+func (c *CWebP) runToFS(ctx context.Context) error {
+	if c.skipIfLarger || c.skipUnchanged {
+		return errors.New("SkipIfLarger and SkipIfUnchanged can't be combined with an output filesystem")
+	}
+
+	outputFile, fs := c.outputFile, c.outputFS
+	defer func() { c.outputFile, c.output, c.outputFS = outputFile, nil, fs }()
+
+	f, err := fs.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	c.outputFS = nil
+	c.Output(f)
+	err = c.RunWithContext(ctx)
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close output file: %w", closeErr)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package webpwrap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+// memFS is an in-memory WritableFS.
+type memFS map[string]*memFile
+
+// memFile is a file of a memFS.
+type memFile struct {
+	*bytes.Buffer
+	closed bool
+}
+
+func (f *memFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (fs memFS) Create(name string) (io.WriteCloser, error) {
+	if name == "" {
+		return nil, errors.New("empty name")
+	}
+	fs[name] = &memFile{Buffer: &bytes.Buffer{}}
+	return fs[name], nil
+}
+
+func TestEncodeOutputFS(t *testing.T) {
+	fs := memFS{}
+	c := NewCWebP().SetOutputFS(fs)
+	c.InputFile("source.jpg")
+	c.OutputFile("images/target.webp")
+	assert.Nil(t, c.Run())
+
+	// Nothing is written to the disk.
+	_, err := os.Stat("images/target.webp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	img, err := webp.Decode(bytes.NewReader(fs["images/target.webp"].Bytes()))
+	assert.Nil(t, err)
+	assert.NotNil(t, img)
+	assert.True(t, fs["images/target.webp"].closed)
+
+	// The output filesystem is kept for further runs.
+	c.OutputFile("second.webp")
+	assert.Nil(t, c.Run())
+	assert.NotZero(t, fs["second.webp"].Len())
+
+	c.SkipIfLarger()
+	assert.NotNil(t, c.Run())
+}
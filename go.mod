@@ -4,6 +4,7 @@ go 1.24.1
 
 require (
 	github.com/belphemur/go-binwrapper v0.0.0-20240827152605-33977349b1f0
+	github.com/klauspost/compress v1.18.0
 	github.com/stretchr/testify v1.10.0
 	golang.org/x/image v0.25.0
 )
@@ -14,7 +15,6 @@ require (
 	github.com/dsnet/compress v0.0.1 // indirect
 	github.com/golang/snappy v1.0.0 // indirect
 	github.com/jfrog/archiver/v3 v3.6.1 // indirect
-	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/klauspost/pgzip v1.2.6 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/nwaples/rardecode v1.1.3 // indirect
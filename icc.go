@@ -0,0 +1,237 @@
+package webpwrap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+)
+
+// xyzD50ToLinearSRGB converts PCS (D50) XYZ values to linear sRGB, including the Bradford adaptation to D65.
+var xyzD50ToLinearSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// iccTransform converts RGB values described by a matrix/TRC ICC profile to sRGB.
+type iccTransform struct {
+	curves [3][256]float64 // Linearization tables of the red, green and blue channels
+	matrix [3][3]float64   // Conversion from linear profile RGB to linear sRGB
+}
+
+// readICCProfile returns the payload of the ICCP chunk of a WebP file.
+// Returns nil if the file has no embedded color profile.
+func readICCProfile(r io.Reader) ([]byte, error) {
+	var profile []byte
+
+	err := walkChunks(r, func(fourCC string, size uint32, payload io.Reader) error {
+		if fourCC != "ICCP" {
+			return nil
+		}
+
+		data, err := io.ReadAll(payload)
+		if err != nil {
+			return err
+		}
+		profile = data
+		return errStopWalk
+	})
+
+	return profile, err
+}
+
+// newICCTransform parses a matrix/TRC RGB profile.
+// Profiles based on lookup tables (for example most CMYK and some camera profiles) are not supported.
+func newICCTransform(profile []byte) (*iccTransform, error) {
+	if len(profile) < 132 {
+		return nil, errors.New("invalid ICC profile: too short")
+	}
+
+	if string(profile[16:20]) != "RGB " {
+		return nil, fmt.Errorf("unsupported ICC profile color space %q", profile[16:20])
+	}
+
+	if string(profile[20:24]) != "XYZ " {
+		return nil, fmt.Errorf("unsupported ICC profile connection space %q", profile[20:24])
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(profile[128:132]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(profile) {
+			return nil, errors.New("invalid ICC profile: truncated tag table")
+		}
+
+		signature := string(profile[entry : entry+4])
+		offset := int(binary.BigEndian.Uint32(profile[entry+4 : entry+8]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8 : entry+12]))
+		if offset < 0 || size < 0 || offset+size > len(profile) {
+			return nil, fmt.Errorf("invalid ICC profile: tag %q out of bounds", signature)
+		}
+		tags[signature] = profile[offset : offset+size]
+	}
+
+	t := &iccTransform{}
+	var primaries [3][3]float64
+
+	for i, channel := range []string{"r", "g", "b"} {
+		xyz, ok := tags[channel+"XYZ"]
+		if !ok {
+			return nil, errors.New("unsupported ICC profile: not a matrix/TRC profile")
+		}
+		if len(xyz) < 20 || string(xyz[0:4]) != "XYZ " {
+			return nil, fmt.Errorf("invalid ICC profile: bad %sXYZ tag", channel)
+		}
+		for j := 0; j < 3; j++ {
+			primaries[j][i] = s15Fixed16(xyz[8+j*4:])
+		}
+
+		trc, ok := tags[channel+"TRC"]
+		if !ok {
+			return nil, errors.New("unsupported ICC profile: not a matrix/TRC profile")
+		}
+		curve, err := parseICCCurve(trc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ICC profile: bad %sTRC tag: %w", channel, err)
+		}
+		for v := 0; v < 256; v++ {
+			t.curves[i][v] = curve(float64(v) / 255)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				t.matrix[i][j] += xyzD50ToLinearSRGB[i][k] * primaries[k][j]
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// parseICCCurve parses a curveType or parametricCurveType tag into a function on [0, 1].
+func parseICCCurve(data []byte) (func(float64) float64, error) {
+	if len(data) < 12 {
+		return nil, errors.New("too short")
+	}
+
+	switch string(data[0:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:12]))
+		if len(data) < 12+n*2 {
+			return nil, errors.New("truncated curve")
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(data[12:14])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		default:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(data[12+i*2:])) / 65535
+			}
+			return func(x float64) float64 {
+				pos := x * float64(n-1)
+				i := int(pos)
+				if i >= n-1 {
+					return table[n-1]
+				}
+				frac := pos - float64(i)
+				return table[i]*(1-frac) + table[i+1]*frac
+			}, nil
+		}
+	case "para":
+		function := binary.BigEndian.Uint16(data[8:10])
+		counts := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+		n, ok := counts[function]
+		if !ok {
+			return nil, fmt.Errorf("unsupported parametric curve type %d", function)
+		}
+		if len(data) < 12+n*4 {
+			return nil, errors.New("truncated parametric curve")
+		}
+		p := make([]float64, 7)
+		for i := 0; i < n; i++ {
+			p[i] = s15Fixed16(data[12+i*4:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		return func(x float64) float64 {
+			switch function {
+			case 1:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g)
+				}
+				return 0
+			case 2:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			case 3:
+				if x >= d {
+					return math.Pow(a*x+b, g)
+				}
+				return c * x
+			case 4:
+				if x >= d {
+					return math.Pow(a*x+b, g) + e
+				}
+				return c*x + f
+			default:
+				return math.Pow(x, g)
+			}
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported curve type %q", data[0:4])
+}
+
+// apply converts the image to sRGB. The alpha channel is left unchanged.
+func (t *iccTransform) apply(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	out, ok := img.(*image.NRGBA)
+	if !ok {
+		out = image.NewNRGBA(bounds)
+		draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			px := out.NRGBAAt(x, y)
+			linear := [3]float64{t.curves[0][px.R], t.curves[1][px.G], t.curves[2][px.B]}
+
+			var rgb [3]uint8
+			for i := 0; i < 3; i++ {
+				v := t.matrix[i][0]*linear[0] + t.matrix[i][1]*linear[1] + t.matrix[i][2]*linear[2]
+				v = math.Max(0, math.Min(1, v))
+				rgb[i] = uint8(math.Round(encodeSRGB(v) * 255))
+			}
+
+			out.SetNRGBA(x, y, color.NRGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: px.A})
+		}
+	}
+
+	return out
+}
+
+// encodeSRGB applies the sRGB transfer function to a linear value in [0, 1].
+func encodeSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// s15Fixed16 decodes an ICC s15Fixed16Number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
@@ -0,0 +1,117 @@
+package webpwrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// displayP3Primaries holds the D50-adapted red, green and blue colorants of Display P3.
+var displayP3Primaries = [3][3]float64{
+	{0.5151, 0.2412, -0.0011},
+	{0.2920, 0.6922, 0.0419},
+	{0.1571, 0.0666, 0.7841},
+}
+
+// buildMatrixTRCProfile assembles a minimal matrix/TRC RGB profile with the given colorants
+// and the sRGB transfer function for all channels.
+func buildMatrixTRCProfile(primaries [3][3]float64) []byte {
+	fixed := func(v float64) []byte {
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v*65536))))
+	}
+
+	type tag struct {
+		signature string
+		data      []byte
+	}
+
+	var tags []tag
+	for i, channel := range []string{"r", "g", "b"} {
+		xyz := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range primaries[i] {
+			xyz = append(xyz, fixed(v)...)
+		}
+		tags = append(tags, tag{channel + "XYZ", xyz})
+
+		trc := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+		for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+			trc = append(trc, fixed(v)...)
+		}
+		tags = append(tags, tag{channel + "TRC", trc})
+	}
+
+	header := make([]byte, 128)
+	copy(header[16:], "RGB XYZ ")
+	copy(header[36:], "acsp")
+
+	var table, data bytes.Buffer
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	offset := 128 + 4 + 12*len(tags)
+	for _, t := range tags {
+		table.WriteString(t.signature)
+		binary.Write(&table, binary.BigEndian, uint32(offset+data.Len()))
+		binary.Write(&table, binary.BigEndian, uint32(len(t.data)))
+		data.Write(t.data)
+	}
+
+	profile := append(header, table.Bytes()...)
+	profile = append(profile, data.Bytes()...)
+	binary.BigEndian.PutUint32(profile[0:4], uint32(len(profile)))
+	return profile
+}
+
+func TestICCTransformDisplayP3(t *testing.T) {
+	transform, err := newICCTransform(buildMatrixTRCProfile(displayP3Primaries))
+	assert.Nil(t, err)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 128, G: 128, B: 128, A: 10})
+
+	out := transform.apply(img)
+
+	// Colors become more saturated when moving from P3 to the smaller sRGB gamut.
+	px := out.NRGBAAt(0, 0)
+	assert.Greater(t, px.R, uint8(200))
+	assert.Less(t, px.B, uint8(50))
+	assert.Equal(t, uint8(255), px.A)
+
+	// Neutral colors share the same white point and transfer function and stay in place.
+	px = out.NRGBAAt(1, 0)
+	assert.InDelta(t, 128, int(px.R), 1)
+	assert.InDelta(t, 128, int(px.G), 1)
+	assert.InDelta(t, 128, int(px.B), 1)
+	assert.Equal(t, uint8(10), px.A)
+}
+
+func TestICCTransformUnsupported(t *testing.T) {
+	_, err := newICCTransform([]byte("too short"))
+	assert.NotNil(t, err)
+
+	profile := buildMatrixTRCProfile(displayP3Primaries)
+	copy(profile[16:], "CMYK")
+	_, err = newICCTransform(profile)
+	assert.NotNil(t, err)
+}
+
+func TestParseICCCurve(t *testing.T) {
+	curve, err := parseICCCurve([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x00"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, curve(0.5))
+
+	curve, err = parseICCCurve([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x00"))
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.25, curve(0.5), 1e-9)
+
+	curve, err = parseICCCurve([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\xff\xff"))
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.5, curve(0.5), 1e-9)
+
+	_, err = parseICCCurve([]byte("mAB \x00\x00\x00\x00\x00\x00\x00\x00"))
+	assert.NotNil(t, err)
+}
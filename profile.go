@@ -0,0 +1,53 @@
+package webpwrap
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// profiles holds the encoding profiles registered with RegisterProfile.
+var profiles = struct {
+	sync.RWMutex
+	byName map[string]func(*CWebP)
+}{byName: map[string]func(*CWebP){}}
+
+// RegisterProfile registers a named encoding profile, such as "thumbnail" or "icon".
+// The configure function is called on every CWebP created with NewCWebPFromProfile
+// and sets the encoding options of the profile, for example Quality or Lossless.
+// It should not set the input or output. Registering a profile with an existing name replaces it.
+// RegisterProfile is safe for concurrent use.
+func RegisterProfile(name string, configure func(*CWebP)) {
+	profiles.Lock()
+	defer profiles.Unlock()
+	profiles.byName[name] = configure
+}
+
+// Profiles returns the names of the registered encoding profiles in sorted order.
+func Profiles() []string {
+	profiles.RLock()
+	defer profiles.RUnlock()
+
+	names := make([]string, 0, len(profiles.byName))
+	for name := range profiles.byName {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewCWebPFromProfile creates a new CWebP instance with the given options
+// and applies the encoding profile registered under name.
+// Returns an error if no profile with the name is registered.
+func NewCWebPFromProfile(name string, optionFuncs ...OptionFunc) (*CWebP, error) {
+	profiles.RLock()
+	configure, ok := profiles.byName[name]
+	profiles.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding profile %q", name)
+	}
+
+	c := NewCWebP(optionFuncs...)
+	configure(c)
+	return c, nil
+}
@@ -0,0 +1,35 @@
+package webpwrap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeProfile(t *testing.T) {
+	RegisterProfile("test-thumbnail", func(c *CWebP) {
+		c.Quality(40).OptimizeAlpha()
+	})
+	defer func() {
+		profiles.Lock()
+		delete(profiles.byName, "test-thumbnail")
+		profiles.Unlock()
+	}()
+	assert.Contains(t, Profiles(), "test-thumbnail")
+
+	var fromProfile, manual bytes.Buffer
+	c, err := NewCWebPFromProfile("test-thumbnail")
+	assert.Nil(t, err)
+	assert.Nil(t, c.InputFile("source.jpg").Output(&fromProfile).Run())
+
+	m := NewCWebP().Quality(40).OptimizeAlpha()
+	assert.Nil(t, m.InputFile("source.jpg").Output(&manual).Run())
+
+	assert.Equal(t, m.summary.Args, c.summary.Args)
+	assert.Contains(t, c.summary.Args, "40")
+	assert.Equal(t, manual.Bytes(), fromProfile.Bytes())
+
+	_, err = NewCWebPFromProfile("missing")
+	assert.ErrorContains(t, err, "missing")
+}
@@ -0,0 +1,282 @@
+package webpwrap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// VP8X feature flags as defined by the WebP container specification.
+const (
+	vp8xFlagAnimation = 0x02
+	vp8xFlagXMP       = 0x04
+	vp8xFlagEXIF      = 0x08
+	vp8xFlagAlpha     = 0x10
+	vp8xFlagICC       = 0x20
+)
+
+// errStopWalk can be returned by a walkChunks callback to stop walking without an error.
+var errStopWalk = errors.New("stop walking chunks")
+
+// webpInfo holds the features of a WebP file as declared by its headers.
+type webpInfo struct {
+	width    int  // Canvas width in pixels
+	height   int  // Canvas height in pixels
+	hasAlpha bool // Whether the image has an alpha channel
+	animated bool // Whether the image is an animation
+	lossless bool // Whether the (first) image bitstream is lossless
+}
+
+// walkChunks reads the RIFF header of a WebP file from r and calls fn for every chunk in order.
+// The chunk payload passed to fn is limited to the chunk size; unread payload data and
+// padding bytes are skipped automatically, so fn only needs to read what it is interested in.
+// Chunks are walked by their declared sizes, so unknown chunks at any position are tolerated.
+// If fn returns errStopWalk, walking stops and walkChunks returns nil.
+func walkChunks(r io.Reader, fn func(fourCC string, size uint32, payload io.Reader) error) error {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return fmt.Errorf("failed to read RIFF header: %w", err)
+	}
+
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return errors.New("not a WebP file")
+	}
+
+	// The RIFF size covers the "WEBP" FourCC and all chunks.
+	remaining := int64(binary.LittleEndian.Uint32(header[4:8])) - 4
+	if remaining < 0 {
+		return errors.New("invalid RIFF size")
+	}
+
+	for remaining > 0 {
+		var chunkHeader [8]byte
+		if remaining < int64(len(chunkHeader)) {
+			return errors.New("truncated chunk header")
+		}
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return fmt.Errorf("failed to read chunk header: %w", err)
+		}
+		remaining -= int64(len(chunkHeader))
+
+		fourCC := string(chunkHeader[0:4])
+		size := binary.LittleEndian.Uint32(chunkHeader[4:8])
+		padded := int64(size) + int64(size&1)
+		if padded > remaining {
+			// Tolerate a missing padding byte on the last chunk.
+			if int64(size) != remaining {
+				return fmt.Errorf("chunk %q exceeds RIFF size", fourCC)
+			}
+			padded = remaining
+		}
+
+		payload := &io.LimitedReader{R: r, N: int64(size)}
+		if err := fn(fourCC, size, payload); err != nil {
+			if err == errStopWalk {
+				return nil
+			}
+			return err
+		}
+
+		if _, err := io.Copy(io.Discard, payload); err != nil {
+			return fmt.Errorf("failed to skip chunk %q: %w", fourCC, err)
+		}
+		if payload.N > 0 {
+			return fmt.Errorf("truncated chunk %q", fourCC)
+		}
+		if padded > int64(size) {
+			if _, err := io.CopyN(io.Discard, r, padded-int64(size)); err != nil {
+				return fmt.Errorf("failed to skip padding of chunk %q: %w", fourCC, err)
+			}
+		}
+		remaining -= padded
+	}
+
+	return nil
+}
+
+// readWebPInfo reads the features of a WebP file from its headers without decoding the image.
+// The chunk list is walked until the first image bitstream, so extra chunks such as
+// ICCP, EXIF or unknown ones before or after the bitstream don't affect the result.
+func readWebPInfo(r io.Reader) (*webpInfo, error) {
+	var info *webpInfo
+	var extended *webpInfo
+
+	err := walkChunks(r, func(fourCC string, size uint32, payload io.Reader) error {
+		switch fourCC {
+		case "VP8X":
+			var data [10]byte
+			if _, err := io.ReadFull(payload, data[:]); err != nil {
+				return fmt.Errorf("invalid VP8X chunk: %w", err)
+			}
+			extended = &webpInfo{
+				width:    int(uint32(data[4])|uint32(data[5])<<8|uint32(data[6])<<16) + 1,
+				height:   int(uint32(data[7])|uint32(data[8])<<8|uint32(data[9])<<16) + 1,
+				hasAlpha: data[0]&vp8xFlagAlpha != 0,
+				animated: data[0]&vp8xFlagAnimation != 0,
+			}
+			if extended.animated {
+				info = extended
+				return errStopWalk
+			}
+		case "VP8 ":
+			width, height, err := readVP8Header(payload)
+			if err != nil {
+				return err
+			}
+			info = &webpInfo{width: width, height: height}
+			return errStopWalk
+		case "VP8L":
+			width, height, alpha, err := readVP8LHeader(payload)
+			if err != nil {
+				return err
+			}
+			info = &webpInfo{width: width, height: height, hasAlpha: alpha, lossless: true}
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		return nil, errors.New("no image data found")
+	}
+
+	if extended != nil && !extended.animated {
+		extended.lossless = info.lossless
+		info = extended
+	}
+
+	return info, nil
+}
+
+// readVP8Header reads the dimensions from the frame header of a lossy VP8 bitstream.
+func readVP8Header(r io.Reader) (int, int, error) {
+	var data [10]byte
+	if _, err := io.ReadFull(r, data[:]); err != nil {
+		return 0, 0, fmt.Errorf("invalid VP8 chunk: %w", err)
+	}
+
+	if data[0]&1 != 0 {
+		return 0, 0, errors.New("invalid VP8 chunk: not a key frame")
+	}
+
+	if data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+		return 0, 0, errors.New("invalid VP8 chunk: bad start code")
+	}
+
+	width := int(binary.LittleEndian.Uint16(data[6:8]) & 0x3fff)
+	height := int(binary.LittleEndian.Uint16(data[8:10]) & 0x3fff)
+	return width, height, nil
+}
+
+// readVP8LHeader reads the dimensions and alpha hint from the header of a lossless VP8L bitstream.
+func readVP8LHeader(r io.Reader) (int, int, bool, error) {
+	var data [5]byte
+	if _, err := io.ReadFull(r, data[:]); err != nil {
+		return 0, 0, false, fmt.Errorf("invalid VP8L chunk: %w", err)
+	}
+
+	if data[0] != 0x2f {
+		return 0, 0, false, errors.New("invalid VP8L chunk: bad signature")
+	}
+
+	bits := binary.LittleEndian.Uint32(data[1:5])
+	if bits>>29 != 0 {
+		return 0, 0, false, errors.New("invalid VP8L chunk: unsupported version")
+	}
+
+	width := int(bits&0x3fff) + 1
+	height := int((bits>>14)&0x3fff) + 1
+	alpha := (bits>>28)&1 != 0
+	return width, height, alpha, nil
+}
+
+// DecodeConfig returns the color model and dimensions of a WebP image without decoding the image.
+// Only the headers are read; the chunk list is walked according to the declared chunk sizes,
+// so files with leading or trailing metadata (ICCP, EXIF, XMP) or unknown chunks are supported.
+// For animations, the canvas dimensions are returned.
+//
+// Parameters:
+//   - r: The io.Reader containing the WebP image data
+//
+// Returns:
+//   - image.Config: The color model and dimensions of the image
+//   - error: Any error encountered while reading the headers
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	info, err := readWebPInfo(r)
+	if err != nil {
+		return image.Config{}, fmt.Errorf("failed to read WebP header: %w", err)
+	}
+
+	var model color.Model
+	switch {
+	case info.lossless || info.animated:
+		model = color.NRGBAModel
+	case info.hasAlpha:
+		model = color.NYCbCrAModel
+	default:
+		model = color.YCbCrModel
+	}
+
+	return image.Config{
+		ColorModel: model,
+		Width:      info.width,
+		Height:     info.height,
+	}, nil
+}
+
+// chunkNames maps the chunk names accepted by Get to their FourCC, like the webpmux -get options.
+var chunkNames = map[string]string{
+	"icc":  "ICCP",
+	"exif": "EXIF",
+	"xmp":  "XMP ",
+}
+
+// Get reads a WebP file from r and returns the raw payload of the named chunk, like webpmux -get.
+// The chunk is named either "icc", "exif" or "xmp" or by its FourCC, e.g. "ICCP" or a custom
+// chunk embedded by another tool. FourCCs must consist of four printable ASCII characters;
+// shorter names are padded with spaces, so "XMP" names the "XMP " chunk.
+// If the file holds several chunks with the FourCC, the first one is returned.
+//
+// Parameters:
+//   - r: The io.Reader containing the WebP file
+//   - chunk: The name or FourCC of the chunk
+//
+// Returns:
+//   - []byte: The payload of the chunk, or nil if the file has no such chunk
+//   - error: Any error encountered while reading the file, or if the chunk name is invalid
+func Get(r io.Reader, chunk string) ([]byte, error) {
+	fourCC, ok := chunkNames[chunk]
+	if !ok {
+		if len(chunk) == 0 || len(chunk) > 4 {
+			return nil, fmt.Errorf("invalid chunk name %q", chunk)
+		}
+		for _, c := range []byte(chunk) {
+			if c < 0x20 || c > 0x7e {
+				return nil, fmt.Errorf("invalid chunk name %q", chunk)
+			}
+		}
+		fourCC = chunk + "    "[len(chunk):]
+	}
+
+	var payload []byte
+	err := walkChunks(r, func(name string, size uint32, data io.Reader) error {
+		if name != fourCC {
+			return nil
+		}
+		var err error
+		if payload, err = io.ReadAll(data); err != nil {
+			return fmt.Errorf("failed to read chunk %q: %w", name, err)
+		}
+		return errStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,186 @@
+package webpwrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+type testChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// buildRIFF assembles a WebP container from the given chunks.
+func buildRIFF(chunks ...testChunk) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for _, c := range chunks {
+		body.WriteString(c.fourCC)
+		binary.Write(&body, binary.LittleEndian, uint32(len(c.data)))
+		body.Write(c.data)
+		if len(c.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(body.Len()))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+// vp8xChunk builds a VP8X chunk with the given flags and canvas size.
+func vp8xChunk(flags byte, width, height int) testChunk {
+	data := make([]byte, 10)
+	data[0] = flags
+	w, h := uint32(width-1), uint32(height-1)
+	data[4], data[5], data[6] = byte(w), byte(w>>8), byte(w>>16)
+	data[7], data[8], data[9] = byte(h), byte(h>>8), byte(h>>16)
+	return testChunk{"VP8X", data}
+}
+
+// readSourceBitstream returns the first chunk of source.webp, which holds the image bitstream.
+func readSourceBitstream(t *testing.T) testChunk {
+	data, err := os.ReadFile("source.webp")
+	assert.Nil(t, err)
+
+	var chunk testChunk
+	err = walkChunks(bytes.NewReader(data), func(fourCC string, size uint32, payload io.Reader) error {
+		if fourCC == "VP8 " || fourCC == "VP8L" {
+			b, err := io.ReadAll(payload)
+			chunk = testChunk{fourCC, b}
+			if err != nil {
+				return err
+			}
+			return errStopWalk
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, chunk.data)
+	return chunk
+}
+
+func TestDecodeConfig(t *testing.T) {
+	f, err := os.Open("source.webp")
+	assert.Nil(t, err)
+	defer f.Close()
+	expected, err := webp.DecodeConfig(f)
+	assert.Nil(t, err)
+	f.Seek(0, 0)
+
+	config, err := DecodeConfig(f)
+	assert.Nil(t, err)
+	assert.Equal(t, expected.Width, config.Width)
+	assert.Equal(t, expected.Height, config.Height)
+}
+
+func TestDecodeConfigLeadingICC(t *testing.T) {
+	bitstream := readSourceBitstream(t)
+	config, err := webp.DecodeConfig(bytes.NewReader(buildRIFF(bitstream)))
+	assert.Nil(t, err)
+
+	data := buildRIFF(
+		vp8xChunk(vp8xFlagICC, config.Width, config.Height),
+		testChunk{"ICCP", make([]byte, 21)},
+		bitstream,
+	)
+
+	result, err := DecodeConfig(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, config.Width, result.Width)
+	assert.Equal(t, config.Height, result.Height)
+	assert.Equal(t, color.YCbCrModel, result.ColorModel)
+}
+
+func TestDecodeConfigTrailingUnknownChunks(t *testing.T) {
+	bitstream := readSourceBitstream(t)
+	config, err := webp.DecodeConfig(bytes.NewReader(buildRIFF(bitstream)))
+	assert.Nil(t, err)
+
+	data := buildRIFF(
+		bitstream,
+		testChunk{"ABCD", []byte{1, 2, 3}},
+		testChunk{"EXIF", []byte("exif")},
+	)
+
+	result, err := DecodeConfig(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, config.Width, result.Width)
+	assert.Equal(t, config.Height, result.Height)
+
+	info, err := readWebPInfo(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.False(t, info.animated)
+}
+
+func TestDecodeConfigAnimated(t *testing.T) {
+	data := buildRIFF(
+		vp8xChunk(vp8xFlagAnimation|vp8xFlagAlpha, 320, 240),
+		testChunk{"ANIM", make([]byte, 6)},
+	)
+
+	info, err := readWebPInfo(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.True(t, info.animated)
+	assert.True(t, info.hasAlpha)
+	assert.Equal(t, 320, info.width)
+	assert.Equal(t, 240, info.height)
+}
+
+func TestDecodeConfigInvalid(t *testing.T) {
+	_, err := DecodeConfig(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00WAVE")))
+	assert.NotNil(t, err)
+
+	data := buildRIFF(testChunk{"VP8 ", make([]byte, 20)})
+	data = data[:len(data)-5]
+	_, err = DecodeConfig(bytes.NewReader(data))
+	assert.NotNil(t, err)
+}
+
+func TestGet(t *testing.T) {
+	bitstream := readSourceBitstream(t)
+	config, err := webp.DecodeConfig(bytes.NewReader(buildRIFF(bitstream)))
+	assert.Nil(t, err)
+
+	data := buildRIFF(
+		vp8xChunk(vp8xFlagEXIF|vp8xFlagXMP, config.Width, config.Height),
+		bitstream,
+		testChunk{"EXIF", []byte("exif data")},
+		testChunk{"XMP ", []byte("<x:xmpmeta/>")},
+		testChunk{"CSTM", []byte("custom")},
+	)
+
+	exif, err := Get(bytes.NewReader(data), "exif")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("exif data"), exif)
+
+	xmp, err := Get(bytes.NewReader(data), "XMP")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("<x:xmpmeta/>"), xmp)
+
+	custom, err := Get(bytes.NewReader(data), "CSTM")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("custom"), custom)
+
+	icc, err := Get(bytes.NewReader(data), "icc")
+	assert.Nil(t, err)
+	assert.Nil(t, icc)
+
+	for _, name := range []string{"", "TOOLONG", "A\x00BC"} {
+		_, err = Get(bytes.NewReader(data), name)
+		assert.ErrorContains(t, err, "invalid chunk name")
+	}
+
+	_, err = Get(strings.NewReader("not a webp file"), "exif")
+	assert.NotNil(t, err)
+}
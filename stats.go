@@ -0,0 +1,133 @@
+package webpwrap
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EncodeStats holds the statistics reported by cwebp for an encode operation.
+// Values that were not reported by the binary are left at zero.
+type EncodeStats struct {
+	OutputBytes int64 // Size of the encoded output in bytes
+	PSNR        PSNR  // Peak signal-to-noise ratio of the output
+}
+
+// PSNR holds the peak signal-to-noise ratios of an encoded image in dB.
+type PSNR struct {
+	Y   float64 // PSNR of the luma plane
+	U   float64 // PSNR of the U chroma plane
+	V   float64 // PSNR of the V chroma plane
+	All float64 // PSNR of the whole image
+}
+
+// shortStatsSince is the first libwebp version assumed to support the condensed statistics of -short.
+// Older versions print the regular statistics, which are parsed instead.
+var shortStatsSince = VersionInfo{Major: 0, Minor: 2, Patch: 0}
+
+// shortStatsPattern matches the condensed statistics line printed with -short, such as "  12345 41.23".
+var shortStatsPattern = regexp.MustCompile(`(?m)^\s*([0-9]+)\s+([0-9]+(?:[.,][0-9]+)?)\s*$`)
+
+// outputStatsPattern matches the output line of the regular statistics,
+// such as "Output: 12345 bytes Y-U-V-All-PSNR 40.12 45.34 46.01 41.23 dB".
+// The PSNR values are missing for lossless outputs.
+var outputStatsPattern = regexp.MustCompile(`(?mi)^\s*output:\s*([0-9]+)\s*bytes(?:\s+y-u-v-all-psnr((?:\s+[0-9]+(?:[.,][0-9]+)?)+)\s*db)?`)
+
+// distortionPattern matches the distortion line printed with -print_psnr, such as
+// "PSNR: Y:40.12 U:45.34 V:46.01 A:99.00  Total:41.23".
+var distortionPattern = regexp.MustCompile(`(?mi)^\s*psnr:\s*(.*)$`)
+
+// distortionValuePattern matches a channel value of the distortion line, such as "Y:40.12".
+var distortionValuePattern = regexp.MustCompile(`([A-Za-z]+):\s*([0-9]+(?:[.,][0-9]+)?)`)
+
+// CollectStats collects the statistics of the encode operation, which are available through Stats
+// after a successful run. If the cwebp version supports it, the condensed statistics of -short
+// are requested, as their format is stable across versions. Otherwise the regular statistics are parsed.
+// The condensed statistics hold the output size and the overall PSNR only.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) CollectStats() *CWebP {
+	c.collectStats = true
+	return c
+}
+
+// PrintPSNR makes cwebp measure the distortion of the output (-print_psnr), which is available
+// through Stats after a successful run. For lossless outputs cwebp measures the B, G, R and alpha
+// channels instead of Y, U and V, so only the overall PSNR is reported for them, as it is when
+// combined with CollectStats. Measuring the distortion decodes the output again, which prolongs the encoding.
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) PrintPSNR(enabled bool) *CWebP {
+	c.printPSNR = enabled
+	return c
+}
+
+// Stats returns the statistics reported by the last successful run with CollectStats or PrintPSNR.
+// Returns nil if neither was enabled for the last run or cwebp reported no statistics.
+func (c *CWebP) Stats() *EncodeStats {
+	return c.stats
+}
+
+// parseStats extracts the statistics printed by cwebp, preferring the condensed format of -short
+// and falling back to the output line of the regular statistics. The distortion printed with
+// -print_psnr takes precedence over the PSNR of the statistics.
+// Returns nil if the output holds none of them.
+func parseStats(stderr []byte) *EncodeStats {
+	var stats *EncodeStats
+	if match := shortStatsPattern.FindSubmatch(stderr); match != nil {
+		stats = &EncodeStats{}
+		stats.OutputBytes, _ = strconv.ParseInt(string(match[1]), 10, 64)
+		stats.PSNR.All = parseStatsFloat(string(match[2]))
+	} else if match := outputStatsPattern.FindSubmatch(stderr); match != nil {
+		stats = &EncodeStats{}
+		stats.OutputBytes, _ = strconv.ParseInt(string(match[1]), 10, 64)
+
+		// The values are printed in the order Y, U, V and All. Fewer values are assigned from the end,
+		// as the overall PSNR is printed last; missing channels are left at zero.
+		values := strings.Fields(string(match[2]))
+		channels := []*float64{&stats.PSNR.Y, &stats.PSNR.U, &stats.PSNR.V, &stats.PSNR.All}
+		values = values[max(len(values)-len(channels), 0):]
+		channels = channels[len(channels)-len(values):]
+		for i, value := range values {
+			*channels[i] = parseStatsFloat(value)
+		}
+	}
+
+	if match := distortionPattern.FindSubmatch(stderr); match != nil {
+		if stats == nil {
+			stats = &EncodeStats{}
+		}
+		stats.PSNR = parseDistortion(match[1])
+	}
+
+	return stats
+}
+
+// parseDistortion parses the channel values of a distortion line such as "Y:40.12 U:45.34 V:46.01 A:99.00  Total:41.23".
+// Lossless outputs are measured in the B, G, R and A channels instead, so only the total is kept for them.
+// Missing channels are left at zero.
+func parseDistortion(values []byte) PSNR {
+	var psnr PSNR
+	for _, match := range distortionValuePattern.FindAllSubmatch(values, -1) {
+		value := parseStatsFloat(string(match[2]))
+		switch strings.ToLower(string(match[1])) {
+		case "y":
+			psnr.Y = value
+		case "u":
+			psnr.U = value
+		case "v":
+			psnr.V = value
+		case "total":
+			psnr.All = value
+		}
+	}
+	return psnr
+}
+
+// parseStatsFloat parses a number printed by cwebp, accepting a decimal comma.
+// Returns zero for malformed numbers.
+func parseStatsFloat(s string) float64 {
+	value, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
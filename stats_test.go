@@ -0,0 +1,128 @@
+package webpwrap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		stats  *EncodeStats
+	}{
+		{"short", "  12345 41.23\n", &EncodeStats{OutputBytes: 12345, PSNR: PSNR{All: 41.23}}},
+		{"short decimal comma", "   987 38,50\n", &EncodeStats{OutputBytes: 987, PSNR: PSNR{All: 38.5}}},
+		{"short after verbose", "Time to encode picture: 0.015s\n   987 38.50\n", &EncodeStats{OutputBytes: 987, PSNR: PSNR{All: 38.5}}},
+		{
+			"regular",
+			"Saving file 'out.webp'\nFile:      source.jpg\nDimension: 280 x 360\n" +
+				"Output:    12345 bytes Y-U-V-All-PSNR 40.12 45.34 46.01   41.23 dB\n           (0.98 bpp)\n",
+			&EncodeStats{OutputBytes: 12345, PSNR: PSNR{Y: 40.12, U: 45.34, V: 46.01, All: 41.23}},
+		},
+		{
+			"regular decimal comma",
+			"Output:    12345 bytes Y-U-V-All-PSNR 40,12 45,34 46,01   41,23 dB\n",
+			&EncodeStats{OutputBytes: 12345, PSNR: PSNR{Y: 40.12, U: 45.34, V: 46.01, All: 41.23}},
+		},
+		{"regular overall only", "Output: 500 bytes Y-U-V-All-PSNR 39.90 dB\n", &EncodeStats{OutputBytes: 500, PSNR: PSNR{All: 39.9}}},
+		{"lossless", "Output:    4321 bytes (0.34 bpp)\nLossless-ARGB compressed size: 4321 bytes\n", &EncodeStats{OutputBytes: 4321}},
+		{
+			"distortion",
+			"Output:    12345 bytes Y-U-V-All-PSNR 40.12 45.34 46.01   41.23 dB\n" +
+				"PSNR: Y:40.15 U:45.30 V:46.05 A:99.00  Total:41.25\n",
+			&EncodeStats{OutputBytes: 12345, PSNR: PSNR{Y: 40.15, U: 45.3, V: 46.05, All: 41.25}},
+		},
+		{
+			"distortion lossless",
+			"Output:    4321 bytes (0.34 bpp)\nPSNR: B:45.10 G:46.20 R:44.90 A:99.00  Total:45.40\n",
+			&EncodeStats{OutputBytes: 4321, PSNR: PSNR{All: 45.4}},
+		},
+		{"distortion decimal comma", "PSNR: Y:40,15 U:45,30 V:46,05 A:99,00  Total:41,25\n", &EncodeStats{PSNR: PSNR{Y: 40.15, U: 45.3, V: 46.05, All: 41.25}}},
+		{"distortion missing channels", "PSNR: Y:40.15 Total:41.25\n", &EncodeStats{PSNR: PSNR{Y: 40.15, All: 41.25}}},
+		{"distortion short", "  12345 41.2500\n", &EncodeStats{OutputBytes: 12345, PSNR: PSNR{All: 41.25}}},
+		{"none", "Saving file 'out.webp'\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.stats, parseStats([]byte(tt.stderr)))
+		})
+	}
+}
+
+func TestCollectStats(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP().CollectStats()
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+
+	assert.Contains(t, c.summary.Args, "-short")
+	if assert.NotNil(t, c.Stats()) {
+		assert.Equal(t, int64(b.Len()), c.Stats().OutputBytes)
+	}
+
+	// Without CollectStats neither -short is passed nor statistics are kept.
+	b.Reset()
+	c.Reset()
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+	assert.False(t, slices.Contains(c.summary.Args, "-short"))
+	assert.Nil(t, c.Stats())
+}
+
+func TestPrintPSNR(t *testing.T) {
+	tests := []struct {
+		name      string
+		set       func(c *CWebP)
+		printPSNR bool
+	}{
+		{"unset", func(c *CWebP) {}, false},
+		{"enabled", func(c *CWebP) { c.PrintPSNR(true) }, true},
+		{"disabled", func(c *CWebP) { c.PrintPSNR(true).PrintPSNR(false) }, false},
+		{"reset", func(c *CWebP) { c.PrintPSNR(true).Reset() }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c := NewCWebP()
+			tt.set(c)
+			c.InputFile("source.jpg")
+			c.Output(&b)
+			assert.Nil(t, c.Run())
+			assert.Equal(t, tt.printPSNR, slices.Contains(c.summary.Args, "-print_psnr"))
+			assert.Equal(t, tt.printPSNR, c.Stats() != nil)
+		})
+	}
+}
+
+func TestCollectStatsWithoutShort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binary is a shell script")
+	}
+
+	// The old binary fails with -short and prints the regular statistics otherwise.
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncase \"$*\" in\n" +
+		"*-version*) echo 0.1.2 ;;\n" +
+		"*-short*) echo \"Unknown option '-short'\" >&2; exit 1 ;;\n" +
+		"*) printf webp; echo 'Output: 4 bytes Y-U-V-All-PSNR 40.00 45.00 46.00 41.00 dB' >&2 ;;\n" +
+		"esac\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "cwebp"), []byte(script), 0755))
+
+	var b bytes.Buffer
+	c := NewCWebP(WithVendorPath(dir)).CollectStats()
+	c.InputFile("source.jpg")
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+	assert.Equal(t, &EncodeStats{OutputBytes: 4, PSNR: PSNR{Y: 40, U: 45, V: 46, All: 41}}, c.Stats())
+}
@@ -0,0 +1,151 @@
+package webpwrap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Framing describes how consecutive images are delimited in a stream.
+//
+// A framed stream is a sequence of frames without any header or trailer.
+// Every frame consists of a length prefix followed by exactly that many bytes of image data:
+//
+//	+----------------+---------------------+----------------+-----
+//	| length (N)     | N bytes image data  | length (M)     | ...
+//	+----------------+---------------------+----------------+-----
+//
+// The Framing value selects the encoding of the length prefix.
+// The stream ends cleanly when EOF is reached at a frame boundary.
+type Framing int
+
+const (
+	// FramingUint32BE prefixes every frame with its length as a 4-byte big-endian unsigned integer.
+	FramingUint32BE Framing = iota
+	// FramingUint32LE prefixes every frame with its length as a 4-byte little-endian unsigned integer.
+	FramingUint32LE
+	// FramingUvarint prefixes every frame with its length as an unsigned varint as defined by encoding/binary.
+	FramingUvarint
+)
+
+// StreamTranscode reads length-prefixed images from r, encodes each of them to WebP
+// and writes the results to w using the same framing.
+// Input images may be in any format supported by cwebp.
+// A single cwebp wrapper is reused for all images in the stream.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - r: The io.Reader containing the framed input images
+//   - w: The io.Writer to write the framed WebP images
+//   - framing: The framing format used for both input and output
+//
+// Returns:
+//   - error: Any error encountered while reading, encoding or writing a frame
+func (e *Encoder) StreamTranscode(ctx context.Context, r io.Reader, w io.Writer, framing Framing) error {
+	if framing < FramingUint32BE || framing > FramingUvarint {
+		return fmt.Errorf("unsupported framing: %d", framing)
+	}
+
+	br := bufio.NewReader(r)
+	c := NewCWebP()
+
+	for i := 0; ; i++ {
+		data, err := readFrame(br, framing)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read frame %d: %w", i, err)
+		}
+
+		var out bytes.Buffer
+		c.Reset().Quality(e.Quality)
+		if e.Lossless {
+			c.Lossless()
+		}
+		if err := c.Input(bytes.NewReader(data)).Output(&out).RunWithContext(ctx); err != nil {
+			return fmt.Errorf("failed to encode frame %d: %w", i, err)
+		}
+
+		if err := writeFrame(w, framing, out.Bytes()); err != nil {
+			return fmt.Errorf("failed to write frame %d: %w", i, err)
+		}
+	}
+}
+
+// StreamTranscode reads length-prefixed images from r, encodes each of them to WebP using
+// default settings and writes the results to w using the same framing.
+// See Encoder.StreamTranscode for details.
+//
+// Parameters:
+//   - ctx: The context for cancellation
+//   - r: The io.Reader containing the framed input images
+//   - w: The io.Writer to write the framed WebP images
+//   - framing: The framing format used for both input and output
+//
+// Returns:
+//   - error: Any error encountered while reading, encoding or writing a frame
+func StreamTranscode(ctx context.Context, r io.Reader, w io.Writer, framing Framing) error {
+	e := &Encoder{Quality: 75}
+	return e.StreamTranscode(ctx, r, w, framing)
+}
+
+// readFrame reads a single frame from r.
+// Returns io.EOF if the stream ends at a frame boundary.
+func readFrame(r *bufio.Reader, framing Framing) ([]byte, error) {
+	var length uint64
+
+	switch framing {
+	case FramingUvarint:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		length = n
+	default:
+		var prefix [4]byte
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			return nil, err
+		}
+		if framing == FramingUint32LE {
+			length = uint64(binary.LittleEndian.Uint32(prefix[:]))
+		} else {
+			length = uint64(binary.BigEndian.Uint32(prefix[:]))
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeFrame writes data to w as a single frame.
+func writeFrame(w io.Writer, framing Framing, data []byte) error {
+	var prefix []byte
+
+	switch framing {
+	case FramingUvarint:
+		prefix = binary.AppendUvarint(nil, uint64(len(data)))
+	case FramingUint32LE:
+		prefix = binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	default:
+		prefix = binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	}
+
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+	return err
+}
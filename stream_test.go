@@ -0,0 +1,59 @@
+package webpwrap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func TestStreamTranscode(t *testing.T) {
+	for _, framing := range []Framing{FramingUint32BE, FramingUint32LE, FramingUvarint} {
+		sizes := []image.Rectangle{
+			image.Rect(0, 0, 16, 16),
+			image.Rect(0, 0, 32, 8),
+			image.Rect(0, 0, 7, 21),
+		}
+
+		var in bytes.Buffer
+		for _, size := range sizes {
+			var b bytes.Buffer
+			err := png.Encode(&b, image.NewNRGBA(size))
+			assert.Nil(t, err)
+			err = writeFrame(&in, framing, b.Bytes())
+			assert.Nil(t, err)
+		}
+
+		var out bytes.Buffer
+		err := StreamTranscode(context.Background(), &in, &out, framing)
+		assert.Nil(t, err)
+
+		r := bufio.NewReader(&out)
+		for _, size := range sizes {
+			data, err := readFrame(r, framing)
+			assert.Nil(t, err)
+			img, err := webp.Decode(bytes.NewReader(data))
+			assert.Nil(t, err)
+			assert.Equal(t, size, img.Bounds())
+		}
+		_, err = readFrame(r, framing)
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestStreamTranscodeTruncated(t *testing.T) {
+	var in bytes.Buffer
+	err := writeFrame(&in, FramingUint32BE, []byte("truncated"))
+	assert.Nil(t, err)
+	in.Truncate(in.Len() - 2)
+
+	var out bytes.Buffer
+	err = StreamTranscode(context.Background(), &in, &out, FramingUint32BE)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
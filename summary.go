@@ -0,0 +1,197 @@
+package webpwrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// sniffSize is the number of leading input bytes kept to describe the input in the summary.
+// It is large enough to cover the metadata segments preceding the frame header of most JPEG files.
+const sniffSize = 64 << 10
+
+// encodeSummary describes a single encode operation. See Summary.
+// A run only records the data it already has; the fields derived from it are filled in by complete
+// once the summary is requested.
+type encodeSummary struct {
+	Version     string   `json:"version"`
+	Args        []string `json:"args"`
+	InputFormat string   `json:"input_format"`
+	InputWidth  int      `json:"input_width"`
+	InputHeight int      `json:"input_height"`
+	OutputBytes int64    `json:"output_bytes"`
+	Quality     int      `json:"quality"`
+	Lossless    bool     `json:"lossless"`
+	DurationMS  float64  `json:"duration_ms"`
+	Warnings    []string `json:"warnings"`
+
+	stderr     []byte        // Output of cwebp, from which the warnings are parsed
+	inputFile  string        // Input file, from which the input format is detected, empty if unused
+	outputFile string        // Output file, whose size is the output size, empty if unused
+	prefix     *prefixBuffer // Leading bytes of streamed input, nil if not kept
+	completed  bool          // Whether the fields derived from the run were filled in
+}
+
+// prefixBuffer keeps the first max bytes written to it and discards the rest.
+type prefixBuffer struct {
+	b   []byte
+	max int
+}
+
+func (p *prefixBuffer) Write(data []byte) (int, error) {
+	if n := min(len(data), p.max-len(p.b)); n > 0 {
+		p.b = append(p.b, data[:n]...)
+	}
+	return len(data), nil
+}
+
+// CollectSummary keeps the leading bytes of streamed input set with Input, so Summary can report
+// its format and dimensions. Other inputs are described without it.
+// The default is false, which reports streamed input with the format "unknown".
+// Returns the CWebP instance for method chaining.
+func (c *CWebP) CollectSummary(enabled bool) *CWebP {
+	c.summaryInput = enabled
+	return c
+}
+
+// Summary returns a JSON description of the last successful run for structured logging.
+// The summary holds the cwebp version, the command-line arguments, the format and dimensions
+// of the input, the output size in bytes, the quality, the duration in milliseconds and
+// any warnings printed by cwebp. For input files and streamed input with CollectSummary,
+// the format and dimensions are detected from the leading input bytes; unknown formats are
+// reported with the format "unknown" and zero dimensions.
+// The summary is built on the first call from the data recorded by the run, so runs don't pay
+// for it unless it is requested. The input and output files are therefore read at that time.
+// Returns an error if there was no successful run yet.
+func (c *CWebP) Summary() ([]byte, error) {
+	if c.summary == nil {
+		return nil, errors.New("no encode summary available")
+	}
+
+	if c.summary.Version == "" {
+		v, err := c.VersionInfo()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cwebp version: %w", err)
+		}
+		c.summary.Version = v.String()
+	}
+
+	c.summary.complete()
+	return json.Marshal(c.summary)
+}
+
+// newSummary records the summary of a successful run that wrote to ow or to the file outputFile.
+// The arguments and the output of cwebp are kept as they are, since resetting the wrapper replaces
+// rather than reuses them.
+func (c *CWebP) newSummary(img image.Image, prefix *prefixBuffer, ow *outputWriter, outputFile string, stderr []byte, elapsed time.Duration) *encodeSummary {
+	summary := &encodeSummary{
+		Args:       c.Args(),
+		Quality:    c.quality,
+		Lossless:   c.lossless || c.effort > -1 || c.nearLossless > -1,
+		DurationMS: float64(elapsed) / float64(time.Millisecond),
+		stderr:     stderr,
+		prefix:     prefix,
+	}
+	if summary.Quality < 0 {
+		summary.Quality = 75
+	}
+
+	if ow != nil {
+		summary.OutputBytes = ow.n
+	} else {
+		summary.outputFile = outputFile
+	}
+
+	switch {
+	case img != nil:
+		summary.InputFormat = "image"
+		summary.InputWidth, summary.InputHeight = img.Bounds().Dx(), img.Bounds().Dy()
+	case c.input == nil:
+		summary.inputFile = c.inputFile
+	}
+	return summary
+}
+
+// complete fills in the fields of the summary that are derived from the data recorded by the run.
+func (s *encodeSummary) complete() {
+	if s.completed {
+		return
+	}
+	s.completed = true
+
+	s.Warnings = parseWarnings(s.stderr)
+
+	if s.outputFile != "" {
+		if info, err := os.Stat(s.outputFile); err == nil {
+			s.OutputBytes = info.Size()
+		}
+	}
+
+	if s.InputFormat != "" {
+		return
+	}
+
+	var data []byte
+	if s.prefix != nil {
+		data = s.prefix.b
+	} else if s.inputFile != "" {
+		if f, err := os.Open(s.inputFile); err == nil {
+			data, _ = io.ReadAll(io.LimitReader(f, sniffSize))
+			f.Close()
+		}
+	}
+	s.InputFormat, s.InputWidth, s.InputHeight = sniffImage(data)
+}
+
+// sniffImage detects the format and dimensions of an encoded image from its leading bytes.
+func sniffImage(data []byte) (string, int, int) {
+	var format string
+	var decodeConfig func(io.Reader) (image.Config, error)
+
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		format = "png"
+		decodeConfig = png.DecodeConfig
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		format = "jpeg"
+		decodeConfig = jpeg.DecodeConfig
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		format = "gif"
+		decodeConfig = gif.DecodeConfig
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		format = "webp"
+		decodeConfig = DecodeConfig
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "tiff", 0, 0
+	default:
+		return "unknown", 0, 0
+	}
+
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return format, 0, 0
+	}
+	return format, config.Width, config.Height
+}
+
+// parseWarnings returns the lines of the output of a libwebp tool that report a warning.
+// See ParseWarnings for categorized warnings.
+func parseWarnings(stderr []byte) []string {
+	warnings := []string{}
+	for _, line := range strings.Split(string(stderr), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(strings.ToLower(line), "warning") {
+			warnings = append(warnings, line)
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,112 @@
+package webpwrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary(t *testing.T) {
+	data, err := os.ReadFile("source.jpg")
+	assert.Nil(t, err)
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	c := NewCWebP().Quality(80).CollectSummary(true)
+	_, err = c.Summary()
+	assert.NotNil(t, err)
+
+	c.Input(bytes.NewReader(data))
+	c.Output(&b)
+	err = c.Run()
+	assert.Nil(t, err)
+
+	encoded, err := c.Summary()
+	assert.Nil(t, err)
+
+	var summary struct {
+		Version     string   `json:"version"`
+		Args        []string `json:"args"`
+		InputFormat string   `json:"input_format"`
+		InputWidth  int      `json:"input_width"`
+		InputHeight int      `json:"input_height"`
+		OutputBytes int64    `json:"output_bytes"`
+		Quality     int      `json:"quality"`
+		Lossless    bool     `json:"lossless"`
+		DurationMS  float64  `json:"duration_ms"`
+		Warnings    []string `json:"warnings"`
+	}
+	assert.Nil(t, json.Unmarshal(encoded, &summary))
+
+	_, err = ParseVersionInfo(summary.Version)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-q", "80", "-o", "-", "--", "-"}, summary.Args)
+	assert.Equal(t, "jpeg", summary.InputFormat)
+	assert.Equal(t, config.Width, summary.InputWidth)
+	assert.Equal(t, config.Height, summary.InputHeight)
+	assert.Equal(t, int64(b.Len()), summary.OutputBytes)
+	assert.Equal(t, 80, summary.Quality)
+	assert.False(t, summary.Lossless)
+	assert.Greater(t, summary.DurationMS, 0.0)
+	assert.NotNil(t, summary.Warnings)
+}
+
+func TestSummaryInput(t *testing.T) {
+	data, err := os.ReadFile("source.jpg")
+	assert.Nil(t, err)
+
+	// Without CollectSummary, streamed input isn't kept to describe it.
+	var b bytes.Buffer
+	c := NewCWebP()
+	assert.Nil(t, c.Input(bytes.NewReader(data)).Output(&b).Run())
+	assert.Nil(t, c.summary.prefix)
+
+	encoded, err := c.Summary()
+	assert.Nil(t, err)
+	var summary map[string]any
+	assert.Nil(t, json.Unmarshal(encoded, &summary))
+	assert.Equal(t, "unknown", summary["input_format"])
+
+	// Input and output files are described once the summary is requested.
+	output := filepath.Join(t.TempDir(), "target.webp")
+	c = NewCWebP()
+	assert.Nil(t, c.InputFile("source.jpg").OutputFile(output).Run())
+	assert.False(t, c.summary.completed)
+
+	encoded, err = c.Summary()
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(encoded, &summary))
+	assert.Equal(t, "jpeg", summary["input_format"])
+	info, err := os.Stat(output)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(info.Size()), summary["output_bytes"])
+}
+
+func TestSummaryImageInput(t *testing.T) {
+	var b bytes.Buffer
+	c := NewCWebP()
+	c.InputImage(image.NewNRGBA(image.Rect(0, 0, 30, 20)))
+	c.Output(&b)
+	assert.Nil(t, c.Run())
+
+	encoded, err := c.Summary()
+	assert.Nil(t, err)
+
+	var summary map[string]any
+	assert.Nil(t, json.Unmarshal(encoded, &summary))
+	assert.Equal(t, "image", summary["input_format"])
+	assert.Equal(t, 30.0, summary["input_width"])
+	assert.Equal(t, 20.0, summary["input_height"])
+	assert.Equal(t, 75.0, summary["quality"])
+}
+
+func TestParseWarnings(t *testing.T) {
+	warnings := parseWarnings([]byte("Saving file 'x.webp'\nWarning: unsupported metadata\nFile: x.png\n"))
+	assert.Equal(t, []string{"Warning: unsupported metadata"}, warnings)
+}
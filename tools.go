@@ -0,0 +1,165 @@
+package webpwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+)
+
+// toolNames lists the binaries of the libwebp release archive run by the package.
+var toolNames = []string{"cwebp", "dwebp"}
+
+// PrepareAll makes sure every tool binary run by the package is present and runnable,
+// downloading the release if required. It can be called at startup to fail early
+// instead of on the first request.
+// The options are applied as for NewCWebP, e.g. to set the vendor path.
+// Returns an error describing every tool that isn't available.
+func PrepareAll(ctx context.Context, optionFuncs ...OptionFunc) error {
+	health := HealthCheck(ctx, optionFuncs...)
+
+	var errs []error
+	for _, tool := range toolNames {
+		if err := health[tool]; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// HealthCheck runs every tool binary used by the package with -version and reports its status.
+// The returned map holds an entry for every tool, with a nil error for tools that are runnable.
+// Missing binaries are downloaded unless downloading is disabled.
+// The options are applied as for NewCWebP, e.g. to set the vendor path.
+func HealthCheck(ctx context.Context, optionFuncs ...OptionFunc) map[string]error {
+	health := make(map[string]error, len(toolNames))
+
+	// The tools are checked one after another, as the first one may download the release for all of them.
+	for _, tool := range toolNames {
+		b := createBinWrapper(optionFuncs...)
+		b.ExecPath(tool)
+		b.Arg("-version")
+
+		if _, _, err := runBinary(ctx, b, tool, nil, nil); err != nil {
+			health[tool] = fmt.Errorf("%s: %w", tool, err)
+		} else {
+			health[tool] = nil
+		}
+	}
+
+	return health
+}
+
+// InstalledBinary describes a tool binary found in the vendor path.
+type InstalledBinary struct {
+	// Path is the resolved path of the binary.
+	Path string
+	// Executable reports whether the binary has the executable permission.
+	// It is always true on Windows.
+	Executable bool
+}
+
+// InstalledBinaries lists the tool binaries used by the package that are found in the vendor path.
+// The returned map holds every tool present on disk, keyed by the tool name.
+// Nothing is downloaded, which makes it suitable for diagnosing partial extractions.
+// Returns an error describing every tool that is missing or not an executable file,
+// along with the map of the tools that were found.
+func InstalledBinaries() (map[string]InstalledBinary, error) {
+	installed := make(map[string]InstalledBinary, len(toolNames))
+
+	var errs []error
+	for _, tool := range toolNames {
+		path := createBinWrapper().ExecPath(tool).Path()
+
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", tool, err))
+			continue
+		case !info.Mode().IsRegular():
+			errs = append(errs, fmt.Errorf("%s: %s is not a regular file", tool, path))
+			continue
+		}
+
+		executable := runtime.GOOS == "windows" || info.Mode().Perm()&0111 != 0
+		installed[tool] = InstalledBinary{Path: path, Executable: executable}
+
+		if !executable {
+			errs = append(errs, fmt.Errorf("%s: %s is not executable", tool, path))
+		}
+	}
+
+	return installed, errors.Join(errs...)
+}
+
+// Sources of the binaries reported by ResolveBinary.
+const (
+	// BinarySourceVendorPath is a binary present in the vendor path.
+	BinarySourceVendorPath = "vendor path"
+	// BinarySourceDownload is a binary missing from the vendor path, which is downloaded into it on first use.
+	BinarySourceDownload = "download"
+	// BinarySourcePATH is a binary found in the system PATH, which is used when the vendor path is empty.
+	BinarySourcePATH = "system PATH"
+)
+
+// ResolveBinary reports the path of the binary that is run for the tool, such as "cwebp",
+// and where it comes from: BinarySourceVendorPath if it is present in the vendor path,
+// or BinarySourceDownload if it is missing and downloaded on first use.
+// With an empty vendor path the binary is run by its name, so it is looked up in the system PATH
+// and reported as BinarySourcePATH; such binaries are never downloaded.
+// Nothing is downloaded. The options are applied as for NewCWebP, e.g. to set the vendor path.
+func ResolveBinary(tool string, optionFuncs ...OptionFunc) (string, string, error) {
+	if !slices.Contains(toolNames, tool) {
+		return "", "", fmt.Errorf("unknown tool %q", tool)
+	}
+
+	path := createBinWrapper(optionFuncs...).ExecPath(tool).Path()
+	if usesPATH(path) {
+		system, err := exec.LookPath(path)
+		if err != nil {
+			return path, "", fmt.Errorf("%s: %w", tool, err)
+		}
+		return system, BinarySourcePATH, nil
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && !info.Mode().IsRegular():
+		return path, "", fmt.Errorf("%s: %s is not a regular file", tool, path)
+	case err == nil:
+		return path, BinarySourceVendorPath, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return path, "", fmt.Errorf("%s: %w", tool, err)
+	case !skipDownload:
+		return path, BinarySourceDownload, nil
+	}
+
+	return path, "", fmt.Errorf("%s: %s is missing and downloading is disabled", tool, path)
+}
+
+// inputFormats lists the input formats of the cwebp binaries of the official libwebp releases.
+var inputFormats = []string{"png", "jpeg", "tiff", "webp", "pam", "pgm", "ppm"}
+
+// outputFormats lists the output formats of the dwebp binaries of the official libwebp releases.
+var outputFormats = []string{"png", "pam", "ppm", "pgm", "bmp", "tiff", "yuv"}
+
+// SupportedInputFormats returns the image formats that cwebp accepts as input.
+// The libwebp tools don't report the image libraries they were built with, so the list is static:
+// it describes the binaries of the official libwebp releases, which are built with PNG, JPEG,
+// TIFF and WebP support. Binaries built from source without one of the image libraries
+// reject the corresponding format when encoding.
+// The formats are lowercase names such as "png" or "jpeg".
+func SupportedInputFormats() []string {
+	return slices.Clone(inputFormats)
+}
+
+// SupportedOutputFormats returns the image formats that dwebp can produce.
+// Like SupportedInputFormats, the list is static and describes the official libwebp releases.
+// DWebP itself writes PNG output; the other formats are listed for callers driving dwebp directly.
+// The formats are lowercase names such as "png" or "bmp".
+func SupportedOutputFormats() []string {
+	return slices.Clone(outputFormats)
+}
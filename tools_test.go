@@ -0,0 +1,152 @@
+package webpwrap
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binaries are shell scripts")
+	}
+
+	previousSkip := skipDownload
+	defer func() { skipDownload = previousSkip }()
+
+	dir := t.TempDir()
+	for _, tool := range toolNames {
+		writeFakeBinary(t, dir, tool, "1.5.0")
+	}
+	ctx := context.Background()
+
+	health := HealthCheck(ctx, SetSkipDownload(true), WithVendorPath(dir))
+	assert.Len(t, health, 2)
+	for _, tool := range []string{"cwebp", "dwebp"} {
+		err, ok := health[tool]
+		assert.True(t, ok, tool)
+		assert.Nil(t, err, tool)
+	}
+	assert.Nil(t, PrepareAll(ctx, WithVendorPath(dir)))
+
+	assert.Nil(t, os.Remove(filepath.Join(dir, "dwebp")))
+	health = HealthCheck(ctx, WithVendorPath(dir))
+	assert.Len(t, health, 2)
+	assert.NotNil(t, health["dwebp"])
+	assert.Nil(t, health["cwebp"])
+
+	err := PrepareAll(ctx, WithVendorPath(dir))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "dwebp")
+	assert.NotContains(t, err.Error(), "cwebp")
+}
+
+func TestSupportedFormats(t *testing.T) {
+	inputs := SupportedInputFormats()
+	assert.NotEmpty(t, inputs)
+	assert.Contains(t, inputs, "png")
+	assert.Contains(t, inputs, "jpeg")
+
+	outputs := SupportedOutputFormats()
+	assert.NotEmpty(t, outputs)
+	assert.Contains(t, outputs, "png")
+
+	// The returned slices are copies.
+	inputs[0] = "modified"
+	assert.Contains(t, SupportedInputFormats(), "png")
+}
+
+func TestInstalledBinaries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binaries are shell scripts")
+	}
+
+	previousDest := dest
+	defer func() { dest = previousDest }()
+
+	dir := t.TempDir()
+	dest = dir
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "cwebp"), []byte("broken"), 0644))
+
+	installed, err := InstalledBinaries()
+	assert.Equal(t, map[string]InstalledBinary{
+		"cwebp": {Path: filepath.Join(dir, "cwebp"), Executable: false},
+	}, installed)
+	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), "dwebp")
+	assert.Contains(t, err.Error(), "cwebp")
+	assert.Contains(t, err.Error(), "not executable")
+
+	assert.Nil(t, os.Chmod(filepath.Join(dir, "cwebp"), 0755))
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "dwebp"), 0755))
+	installed, err = InstalledBinaries()
+	assert.Equal(t, map[string]InstalledBinary{
+		"cwebp": {Path: filepath.Join(dir, "cwebp"), Executable: true},
+	}, installed)
+	assert.ErrorContains(t, err, "dwebp: "+filepath.Join(dir, "dwebp")+" is not a regular file")
+	assert.NotContains(t, err.Error(), "cwebp")
+
+	assert.Nil(t, os.Remove(filepath.Join(dir, "dwebp")))
+	writeFakeBinary(t, dir, "dwebp", "1.5.0")
+	installed, err = InstalledBinaries()
+	assert.Nil(t, err)
+	assert.Len(t, installed, 2)
+	assert.True(t, installed["dwebp"].Executable)
+}
+
+func TestResolveBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binaries are shell scripts")
+	}
+
+	previousSkip := skipDownload
+	defer func() { skipDownload = previousSkip }()
+
+	vendor := t.TempDir()
+	writeFakeBinary(t, vendor, "cwebp", "1.5.0")
+
+	path, source, err := ResolveBinary("cwebp", WithVendorPath(vendor))
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(vendor, "cwebp"), path)
+	assert.Equal(t, BinarySourceVendorPath, source)
+
+	path, source, err = ResolveBinary("dwebp", SetSkipDownload(false), WithVendorPath(vendor))
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(vendor, "dwebp"), path)
+	assert.Equal(t, BinarySourceDownload, source)
+
+	// Binaries in PATH are only used with an empty vendor path.
+	system := t.TempDir()
+	writeFakeBinary(t, system, "dwebp", "1.0.0")
+	t.Setenv("PATH", system)
+	path, source, err = ResolveBinary("dwebp", SetSkipDownload(true), WithVendorPath(vendor))
+	assert.ErrorContains(t, err, "downloading is disabled")
+	assert.Equal(t, filepath.Join(vendor, "dwebp"), path)
+	assert.Empty(t, source)
+
+	path, source, err = ResolveBinary("dwebp", SetSkipDownload(false), WithVendorPath(""))
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(system, "dwebp"), path)
+	assert.Equal(t, BinarySourcePATH, source)
+
+	// The binary resolved from PATH is the one that is run.
+	health := HealthCheck(context.Background(), WithVendorPath(""))
+	assert.Nil(t, health["dwebp"])
+	assert.NotNil(t, health["cwebp"])
+
+	_, source, err = ResolveBinary("cwebp", WithVendorPath(""))
+	assert.ErrorIs(t, err, exec.ErrNotFound)
+	assert.Empty(t, source)
+
+	_, _, err = ResolveBinary("webpmux")
+	assert.ErrorContains(t, err, `unknown tool "webpmux"`)
+
+	_, _, err = ResolveBinary("convert")
+	assert.ErrorContains(t, err, `unknown tool "convert"`)
+}
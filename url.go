@@ -0,0 +1,118 @@
+package webpwrap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/gif"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// defaultMaxURLBytes is the size limit of images fetched by EncodeURL if URLOptions.MaxBytes is unset.
+const defaultMaxURLBytes = 32 << 20
+
+// URLOptions configures EncodeURL.
+type URLOptions struct {
+	// Encoder holds the encoding options. If nil, the default settings of Encode are used.
+	Encoder *Encoder
+
+	// Client fetches the image. If nil, http.DefaultClient is used.
+	// Redirects are followed according to the redirect policy of the client.
+	Client *http.Client
+
+	// MaxBytes limits the size of the fetched image. If zero, the limit is 32 MiB.
+	MaxBytes int64
+}
+
+// EncodeURL fetches the image at imageURL over HTTP and writes it to w in WebP format.
+// The format of the image is detected from its content rather than the Content-Type header.
+// PNG, JPEG, TIFF and WebP images are passed to cwebp as they are, GIF images are decoded first,
+// which encodes their first frame. The WebP data is streamed into w as cwebp produces it.
+// If opts is nil, the default settings are used.
+//
+// Parameters:
+//   - ctx: The context for cancellation, which also applies to the request
+//   - imageURL: The URL of the image to fetch
+//   - w: The io.Writer to write the encoded WebP data
+//   - opts: The options of the request and the encode, or nil for the defaults
+//
+// Returns:
+//   - error: Any error encountered while fetching or encoding; responses other than 200 OK
+//     are reported with their status, images larger than MaxBytes with ErrInputTooLarge
+func EncodeURL(ctx context.Context, imageURL string, w io.Writer, opts *URLOptions) error {
+	if opts == nil {
+		opts = &URLOptions{}
+	}
+	e, client, maxBytes := opts.Encoder, opts.Client, opts.MaxBytes
+	if e == nil {
+		e = &Encoder{Quality: 75}
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxURLBytes
+	}
+
+	data, err := fetchImage(ctx, client, imageURL, maxBytes)
+	if err != nil {
+		return err
+	}
+
+	format, _, _ := sniffImage(data)
+	c := NewCWebP().Quality(e.Quality)
+	if e.Lossless {
+		c.Lossless()
+	}
+
+	switch {
+	case format == "gif":
+		img, err := gif.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", imageURL, err)
+		}
+		c.InputImage(img)
+	case slices.Contains(inputFormats, format):
+		c.Input(bytes.NewReader(data))
+	default:
+		return fmt.Errorf("unsupported image format of %s", imageURL)
+	}
+
+	return c.Output(w).RunWithContext(ctx)
+}
+
+// fetchImage fetches the content at imageURL, failing for responses other than 200 OK
+// and for content larger than maxBytes.
+func fetchImage(ctx context.Context, client *http.Client, imageURL string, maxBytes int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image URL: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to fetch %s: %w", imageURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: unexpected status %s", imageURL, resp.Status)
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("%w: %s has %d bytes, allowed are %d", ErrInputTooLarge, imageURL, resp.ContentLength, maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", imageURL, err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrInputTooLarge, imageURL, maxBytes)
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+package webpwrap
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeURL(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var source bytes.Buffer
+	assert.Nil(t, png.Encode(&source, img))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(source.Bytes())
+	})
+	mux.Handle("/redirect", http.RedirectHandler("/image.png", http.StatusFound))
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not an image"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, path := range []string{"/image.png", "/redirect"} {
+		var b bytes.Buffer
+		err := EncodeURL(context.Background(), server.URL+path, &b, nil)
+		assert.Nil(t, err, path)
+
+		decoded, err := webp.Decode(&b)
+		assert.Nil(t, err, path)
+		assert.Equal(t, img.Bounds(), decoded.Bounds(), path)
+	}
+
+	var b bytes.Buffer
+	opts := &URLOptions{Encoder: &Encoder{Lossless: true}, Client: server.Client()}
+	assert.Nil(t, EncodeURL(context.Background(), server.URL+"/image.png", &b, opts))
+	decoded, err := webp.Decode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, img.NRGBAAt(3, 5), color.NRGBAModel.Convert(decoded.At(3, 5)))
+
+	err = EncodeURL(context.Background(), server.URL+"/missing", &b, nil)
+	assert.ErrorContains(t, err, "404 Not Found")
+
+	err = EncodeURL(context.Background(), server.URL+"/text", &b, nil)
+	assert.ErrorContains(t, err, "unsupported image format")
+
+	err = EncodeURL(context.Background(), server.URL+"/image.png", &b, &URLOptions{MaxBytes: 100})
+	assert.ErrorIs(t, err, ErrInputTooLarge)
+
+	// Redirects are handled by the client.
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	err = EncodeURL(context.Background(), server.URL+"/redirect", &b, &URLOptions{Client: noRedirects})
+	assert.ErrorContains(t, err, "302 Found")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = EncodeURL(ctx, server.URL+"/image.png", &b, nil)
+	assert.ErrorIs(t, err, ErrCanceled)
+}
@@ -0,0 +1,252 @@
+package webpwrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// WebPFormat describes how the image data of a WebP file is stored.
+type WebPFormat string
+
+const (
+	FormatUnknown  WebPFormat = ""         // The format could not be determined
+	FormatLossy    WebPFormat = "lossy"    // Simple file format with a lossy VP8 bitstream
+	FormatLossless WebPFormat = "lossless" // Simple file format with a lossless VP8L bitstream
+	FormatExtended WebPFormat = "extended" // Extended file format (VP8X) with a still image
+	FormatAnimated WebPFormat = "animated" // Extended file format (VP8X) with an animation
+)
+
+// Validation holds the result of validating a WebP file.
+type Validation struct {
+	Valid    bool       // Whether the file is a well-formed WebP file without problems
+	Format   WebPFormat // Storage format of the image data
+	Width    int        // Width of the image or animation canvas in pixels
+	Height   int        // Height of the image or animation canvas in pixels
+	Problems []string   // Structural problems found in the file
+}
+
+// validationChunk is a chunk found while validating a WebP file.
+type validationChunk struct {
+	fourCC  string
+	offset  int
+	payload []byte
+}
+
+// Validate checks the structure of a WebP file and reports detailed diagnostics.
+// It is stricter than DecodeConfig: instead of stopping at the first image bitstream,
+// the whole RIFF structure is checked, including the declared sizes of the file and all chunks,
+// the presence of the chunks required by the file format and the consistency of the VP8X flags.
+// The image data itself is not decoded.
+// Malformed files are reported through the Problems of the returned Validation;
+// the error is only set if reading from r fails.
+//
+// Parameters:
+//   - r: The io.Reader containing the WebP file
+//
+// Returns:
+//   - *Validation: The validation result
+//   - error: Any error encountered while reading r
+func Validate(r io.Reader) (*Validation, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	v := &Validation{}
+	v.check(data)
+	v.Valid = len(v.Problems) == 0
+	return v, nil
+}
+
+// problem records a structural problem.
+func (v *Validation) problem(format string, args ...any) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+}
+
+// check validates the RIFF structure and the chunks of the file.
+func (v *Validation) check(data []byte) {
+	if len(data) < 12 {
+		v.problem("file too short for a RIFF header: %d bytes", len(data))
+		return
+	}
+
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		v.problem("not a WebP file: missing RIFF/WEBP signature")
+		return
+	}
+
+	riffSize := int64(binary.LittleEndian.Uint32(data[4:8]))
+	end := int64(len(data))
+	switch {
+	case riffSize < 4:
+		v.problem("invalid RIFF size %d", riffSize)
+		return
+	case riffSize+8 > end:
+		v.problem("RIFF size %d exceeds the file size of %d bytes", riffSize, len(data))
+	case riffSize+8 < end:
+		v.problem("%d bytes of trailing data after the RIFF payload", end-riffSize-8)
+		end = riffSize + 8
+	}
+	if riffSize%2 != 0 {
+		v.problem("odd RIFF size %d", riffSize)
+	}
+
+	chunks := v.readChunks(data[:end])
+	if len(chunks) == 0 {
+		v.problem("no chunks found")
+		return
+	}
+
+	switch chunks[0].fourCC {
+	case "VP8 ", "VP8L":
+		v.checkSimple(chunks)
+	case "VP8X":
+		v.checkExtended(chunks)
+	default:
+		v.problem("first chunk is %q, expected \"VP8 \", \"VP8L\" or \"VP8X\"", chunks[0].fourCC)
+	}
+}
+
+// readChunks splits the RIFF payload into chunks, recording problems with the chunk sizes.
+func (v *Validation) readChunks(data []byte) []validationChunk {
+	var chunks []validationChunk
+
+	offset := 12
+	for offset < len(data) {
+		if len(data)-offset < 8 {
+			v.problem("truncated chunk header at offset %d", offset)
+			break
+		}
+
+		fourCC := string(data[offset : offset+4])
+		size := int64(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		start := offset + 8
+		if size > int64(len(data)-start) {
+			v.problem("chunk %q at offset %d: size %d exceeds the remaining %d bytes",
+				fourCC, offset, size, len(data)-start)
+			break
+		}
+
+		chunks = append(chunks, validationChunk{fourCC, offset, data[start : start+int(size)]})
+		offset = start + int(size) + int(size&1)
+		if offset > len(data) {
+			v.problem("chunk %q at offset %d: missing padding byte", fourCC, chunks[len(chunks)-1].offset)
+		}
+	}
+
+	return chunks
+}
+
+// checkSimple validates a file in the simple file format, which holds a single bitstream chunk.
+func (v *Validation) checkSimple(chunks []validationChunk) {
+	bitstream := chunks[0]
+	if bitstream.fourCC == "VP8L" {
+		v.Format = FormatLossless
+	} else {
+		v.Format = FormatLossy
+	}
+
+	for _, c := range chunks[1:] {
+		v.problem("unexpected chunk %q at offset %d in a simple format file", c.fourCC, c.offset)
+	}
+
+	v.Width, v.Height, _ = v.checkBitstream(bitstream)
+}
+
+// checkExtended validates a file in the extended file format starting with a VP8X chunk.
+func (v *Validation) checkExtended(chunks []validationChunk) {
+	header := chunks[0]
+	if len(header.payload) < 10 {
+		v.problem("VP8X chunk too short: %d bytes", len(header.payload))
+		return
+	}
+
+	flags := header.payload[0]
+	v.Width = int(uint32(header.payload[4])|uint32(header.payload[5])<<8|uint32(header.payload[6])<<16) + 1
+	v.Height = int(uint32(header.payload[7])|uint32(header.payload[8])<<8|uint32(header.payload[9])<<16) + 1
+
+	present := map[string]int{}
+	var bitstreams []validationChunk
+	for _, c := range chunks[1:] {
+		present[c.fourCC]++
+		switch c.fourCC {
+		case "VP8X":
+			v.problem("duplicate VP8X chunk at offset %d", c.offset)
+		case "VP8 ", "VP8L":
+			bitstreams = append(bitstreams, c)
+		}
+	}
+
+	for _, f := range []struct {
+		flag   byte
+		fourCC string
+	}{
+		{vp8xFlagICC, "ICCP"},
+		{vp8xFlagEXIF, "EXIF"},
+		{vp8xFlagXMP, "XMP "},
+	} {
+		if flags&f.flag != 0 && present[f.fourCC] == 0 {
+			v.problem("VP8X declares a %q chunk that is missing", f.fourCC)
+		} else if flags&f.flag == 0 && present[f.fourCC] > 0 {
+			v.problem("%q chunk present but not declared in VP8X", f.fourCC)
+		}
+	}
+
+	if flags&vp8xFlagAnimation != 0 {
+		v.Format = FormatAnimated
+		if present["ANIM"] == 0 {
+			v.problem("animation without an ANIM chunk")
+		}
+		if present["ANMF"] == 0 {
+			v.problem("animation without ANMF frames")
+		}
+		for _, c := range bitstreams {
+			v.problem("unexpected chunk %q at offset %d in an animation", c.fourCC, c.offset)
+		}
+		return
+	}
+
+	v.Format = FormatExtended
+	if present["ANIM"] > 0 || present["ANMF"] > 0 {
+		v.problem("animation chunks present but animation not declared in VP8X")
+	}
+
+	switch len(bitstreams) {
+	case 0:
+		v.problem("missing image bitstream (\"VP8 \" or \"VP8L\" chunk)")
+		return
+	case 1:
+	default:
+		v.problem("%d image bitstreams found, expected one", len(bitstreams))
+	}
+
+	width, height, ok := v.checkBitstream(bitstreams[0])
+	if ok && (width != v.Width || height != v.Height) {
+		v.problem("bitstream size %dx%d does not match the VP8X canvas size %dx%d", width, height, v.Width, v.Height)
+	}
+
+	if present["ALPH"] > 0 && bitstreams[0].fourCC == "VP8L" {
+		v.problem("ALPH chunk combined with a lossless bitstream")
+	}
+}
+
+// checkBitstream validates the header of a VP8 or VP8L chunk and returns the image dimensions.
+func (v *Validation) checkBitstream(c validationChunk) (int, int, bool) {
+	r := bytes.NewReader(c.payload)
+
+	var width, height int
+	var err error
+	if c.fourCC == "VP8L" {
+		width, height, _, err = readVP8LHeader(r)
+	} else {
+		width, height, err = readVP8Header(r)
+	}
+	if err != nil {
+		v.problem("chunk %q at offset %d: %v", c.fourCC, c.offset, err)
+		return 0, 0, false
+	}
+
+	return width, height, true
+}
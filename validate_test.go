@@ -0,0 +1,90 @@
+package webpwrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func TestValidate(t *testing.T) {
+	data, err := os.ReadFile("source.webp")
+	assert.Nil(t, err)
+	config, err := webp.DecodeConfig(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	v, err := Validate(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.True(t, v.Valid)
+	assert.Empty(t, v.Problems)
+	assert.Equal(t, config.Width, v.Width)
+	assert.Equal(t, config.Height, v.Height)
+	assert.NotEqual(t, FormatUnknown, v.Format)
+}
+
+func TestValidateExtended(t *testing.T) {
+	bitstream := readSourceBitstream(t)
+	config, err := webp.DecodeConfig(bytes.NewReader(buildRIFF(bitstream)))
+	assert.Nil(t, err)
+
+	v, err := Validate(bytes.NewReader(buildRIFF(
+		vp8xChunk(vp8xFlagICC, config.Width, config.Height),
+		testChunk{"ICCP", make([]byte, 21)},
+		bitstream,
+	)))
+	assert.Nil(t, err)
+	assert.True(t, v.Valid, v.Problems)
+	assert.Equal(t, FormatExtended, v.Format)
+}
+
+func TestValidateMalformed(t *testing.T) {
+	bitstream := readSourceBitstream(t)
+	config, err := webp.DecodeConfig(bytes.NewReader(buildRIFF(bitstream)))
+	assert.Nil(t, err)
+
+	valid := buildRIFF(bitstream)
+	badChunkSize := bytes.Clone(valid)
+	binary.LittleEndian.PutUint32(badChunkSize[16:20], uint32(len(bitstream.data)+100))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		problem string
+	}{
+		{"not webp", []byte("RIFF\x04\x00\x00\x00WAVE"), "not a WebP file"},
+		{"too short", []byte("RIFF"), "too short"},
+		{"truncated", valid[:len(valid)-10], "exceeds the file size"},
+		{"bad chunk size", badChunkSize, "exceeds the remaining"},
+		{"trailing data", append(bytes.Clone(valid), 0, 0), "trailing data"},
+		{"missing bitstream", buildRIFF(vp8xChunk(0, config.Width, config.Height)), "missing image bitstream"},
+		{"missing ICCP", buildRIFF(vp8xChunk(vp8xFlagICC, config.Width, config.Height), bitstream), "missing"},
+		{"undeclared EXIF", buildRIFF(vp8xChunk(0, config.Width, config.Height), bitstream, testChunk{"EXIF", []byte("exif")}), "not declared"},
+		{"canvas mismatch", buildRIFF(vp8xChunk(0, config.Width+1, config.Height), bitstream), "does not match"},
+		{"animation without frames", buildRIFF(vp8xChunk(vp8xFlagAnimation, 10, 10), testChunk{"ANIM", make([]byte, 6)}), "without ANMF"},
+		{"bad first chunk", buildRIFF(testChunk{"ICCP", make([]byte, 4)}, bitstream), "first chunk"},
+		{"bad bitstream", buildRIFF(testChunk{"VP8 ", make([]byte, 20)}), "bad start code"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := Validate(bytes.NewReader(tt.data))
+			assert.Nil(t, err)
+			assert.False(t, v.Valid)
+			assert.True(t, containsProblem(v.Problems, tt.problem), "problems %q lack %q", v.Problems, tt.problem)
+		})
+	}
+}
+
+// containsProblem reports whether any of the problems contains the substring.
+func containsProblem(problems []string, substr string) bool {
+	for _, p := range problems {
+		if strings.Contains(p, substr) {
+			return true
+		}
+	}
+	return false
+}
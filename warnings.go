@@ -0,0 +1,83 @@
+package webpwrap
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// WarningCategory classifies the warnings printed by the libwebp tools.
+type WarningCategory int
+
+const (
+	// WarningOther is a warning of none of the other categories.
+	WarningOther WarningCategory = iota
+	// WarningMetadata reports metadata that can't be read or written, such as EXIF or ICC profiles.
+	WarningMetadata
+	// WarningSize reports problems with the dimensions of the image or the output size.
+	WarningSize
+	// WarningQuality reports settings affecting the quality of the output, such as a fallback to lossy compression.
+	WarningQuality
+	// WarningDeprecation reports deprecated or ignored command-line options.
+	WarningDeprecation
+)
+
+// String returns the name of the warning category.
+func (c WarningCategory) String() string {
+	switch c {
+	case WarningOther:
+		return "other"
+	case WarningMetadata:
+		return "metadata"
+	case WarningSize:
+		return "size"
+	case WarningQuality:
+		return "quality"
+	case WarningDeprecation:
+		return "deprecation"
+	}
+	return fmt.Sprintf("WarningCategory(%d)", int(c))
+}
+
+// Warning is a warning printed by one of the libwebp tools.
+type Warning struct {
+	Tool     string          // Name of the tool, such as "cwebp"
+	Category WarningCategory // Category derived from the message
+	Message  string          // Message without the leading "Warning:"
+}
+
+// warningCategories maps keywords of the warning messages to their category.
+// The categories are checked in order, so options mentioned in deprecation notices don't count as settings.
+var warningCategories = []struct {
+	category WarningCategory
+	pattern  *regexp.Regexp
+}{
+	{WarningDeprecation, regexp.MustCompile(`(?i)deprecat|obsolete|no longer`)},
+	{WarningMetadata, regexp.MustCompile(`(?i)metadata|exif|xmp|icc`)},
+	{WarningSize, regexp.MustCompile(`(?i)size|dimension|width|height|too large|too big|crop|resiz`)},
+	{WarningQuality, regexp.MustCompile(`(?i)quality|psnr|lossless|lossy|alpha|compress`)},
+}
+
+// warningPrefix matches the prefix of warning lines, such as "Warning: " or "WARNING! ".
+var warningPrefix = regexp.MustCompile(`(?i)^warning\s*[:!-]?\s*`)
+
+// ParseWarnings returns the warnings in the standard error output of a libwebp tool such as cwebp,
+// dwebp or gif2webp, categorized by their message. The tools print warnings as lines containing
+// "Warning", in varying case and punctuation; other lines are ignored.
+// The tool name is recorded in the warnings.
+func ParseWarnings(stderr []byte, tool string) []Warning {
+	var warnings []Warning
+	for _, line := range parseWarnings(stderr) {
+		message := warningPrefix.ReplaceAllString(line, "")
+
+		category := WarningOther
+		for _, c := range warningCategories {
+			if c.pattern.MatchString(message) {
+				category = c.category
+				break
+			}
+		}
+
+		warnings = append(warnings, Warning{Tool: tool, Category: category, Message: message})
+	}
+	return warnings
+}
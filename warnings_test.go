@@ -0,0 +1,36 @@
+package webpwrap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWarningsCategories(t *testing.T) {
+	tests := []struct {
+		tool     string
+		stderr   string
+		warnings []Warning
+	}{
+		{"cwebp", "Saving file 'out.webp'\nWarning: only ICC profile, EXIF and XMP metadata are supported\n" +
+			"File:      in.png\nWARNING: picture size 20000x20000 is too large, cropping\n", []Warning{
+			{"cwebp", WarningMetadata, "only ICC profile, EXIF and XMP metadata are supported"},
+			{"cwebp", WarningSize, "picture size 20000x20000 is too large, cropping"},
+		}},
+		{"dwebp", "Decoded in.webp. Dimensions: 4 x 4.\nwarning: option -nodither is deprecated\n", []Warning{
+			{"dwebp", WarningDeprecation, "option -nodither is deprecated"},
+		}},
+		{"gif2webp", "Warning: frame 3 falls back to lossy compression\nWarning! loop count 70000 exceeds the maximum\n", []Warning{
+			{"gif2webp", WarningQuality, "frame 3 falls back to lossy compression"},
+			{"gif2webp", WarningOther, "loop count 70000 exceeds the maximum"},
+		}},
+		{"cwebp", "Saving file 'out.webp'\n", nil},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.warnings, ParseWarnings([]byte(tt.stderr), tt.tool))
+	}
+
+	assert.Equal(t, "deprecation", WarningDeprecation.String())
+	assert.Equal(t, "WarningCategory(9)", WarningCategory(9).String())
+}
@@ -2,11 +2,18 @@ package webpwrap
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"image"
 	"image/png"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/belphemur/go-binwrapper"
@@ -15,21 +22,220 @@ import (
 var skipDownload bool
 var dest = ".bin/webp"
 var libwebpVersion = "1.5.0"
+var downloadMirrors []string
+var maxOutputBytes int64
 
-type OptionFunc func(binWrapper *binwrapper.BinWrapper) error
+// ErrOutputWrite is returned when the writer set with Output fails.
+// The returned error wraps both ErrOutputWrite and the original writer error.
+var ErrOutputWrite = errors.New("failed to write output")
+
+// ErrUnsupportedOption is returned when an option is not supported by the installed binary.
+var ErrUnsupportedOption = errors.New("option not supported")
+
+// ErrCannotMeetSize is returned when an image can't be encoded within the size set with MaxOutputBytes,
+// even at the minimum quality.
+var ErrCannotMeetSize = errors.New("cannot meet output size")
+
+// ErrDimensionMismatch is returned when the dimensions of a decoded image differ from
+// the dimensions declared in the WebP header. See StrictDimensions.
+var ErrDimensionMismatch = errors.New("decoded dimensions do not match header")
+
+// ErrOutputTooLarge is returned when a binary writes more output than allowed by SetMaxOutputBytes.
+var ErrOutputTooLarge = errors.New("output too large")
+
+// ErrInputTooLarge is returned when an input exceeds the size allowed for it, such as the MaxBytes of EncodeURL.
+var ErrInputTooLarge = errors.New("input too large")
+
+// ErrUnsupportedVersion is returned when a binary is older than the version set with RequireMinVersion.
+var ErrUnsupportedVersion = errors.New("unsupported binary version")
+
+// ErrPNGDecode is returned when the PNG output of dwebp can't be decoded into an image.
+// See DWebP.OnPNGDecodeError.
+var ErrPNGDecode = errors.New("failed to decode PNG output")
+
+// ErrTimeout is returned when the deadline of the context passed to RunWithContext is exceeded.
+// The returned error also wraps context.DeadlineExceeded.
+var ErrTimeout = errors.New("operation timed out")
+
+// ErrCanceled is returned when the context passed to RunWithContext is canceled.
+// The returned error also wraps context.Canceled.
+var ErrCanceled = errors.New("operation canceled")
+
+// ErrEmptyImage is returned when an image to encode has a width or height of zero or less.
+var ErrEmptyImage = errors.New("empty image")
+
+// ErrIncompatibleOptions is returned when encoding options don't make sense for the input
+// or for each other. See CWebP.CheckOptions.
+var ErrIncompatibleOptions = errors.New("incompatible options")
+
+// VersionInfo is a parsed libwebp version number.
+type VersionInfo struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// versionPattern matches the leading version number of the -version output.
+var versionPattern = regexp.MustCompile(`^\s*(\d+)\.(\d+)\.(\d+)`)
+
+// ParseVersionInfo parses the version number from the output of the -version flag, such as "1.5.0".
+// Additional output following the version number, such as the libsharpyuv version, is ignored.
+func ParseVersionInfo(version string) (VersionInfo, error) {
+	match := versionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return VersionInfo{}, fmt.Errorf("invalid version: %q", version)
+	}
+
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	patch, _ := strconv.Atoi(match[3])
+	return VersionInfo{Major: major, Minor: minor, Patch: patch}, nil
+}
+
+// AtLeast reports whether the version is equal to or newer than major.minor.patch.
+func (v VersionInfo) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// String returns the version in major.minor.patch format.
+func (v VersionInfo) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// OptionFunc is an option of NewCWebP and NewDWebP, which configures the binary wrapper of the instance.
+// Options carry a name, which is reported by AppliedOptions. Custom options are created with NewOption.
+type OptionFunc struct {
+	name       string                                        // Name reported by AppliedOptions
+	apply      func(binWrapper *binwrapper.BinWrapper) error // Configures the binary wrapper, nil if unused
+	minVersion *VersionInfo                                  // Minimum version of the binary, nil if unset
+}
+
+// NewOption creates a custom option that configures the binary wrapper with apply.
+// The name describes the option in AppliedOptions, e.g. "withProxy".
+func NewOption(name string, apply func(binWrapper *binwrapper.BinWrapper) error) OptionFunc {
+	return OptionFunc{name: name, apply: apply}
+}
+
+// versionRequirement is the minimum version of a binary set with RequireMinVersion.
+type versionRequirement struct {
+	min     VersionInfo // minimum version of the binary
+	err     error       // result of the check, valid if checked
+	checked bool        // whether the version of the binary was checked
+}
+
+// RequireMinVersion makes the instance fail unless its binary is at least version major.minor.patch,
+// for callers relying on options of recent libwebp releases.
+// NewCWebP and NewDWebP can't fail, so the version is checked on the first run instead,
+// before anything is encoded or decoded. The result is kept, so the binary isn't queried again
+// by later runs of the instance. Run returns ErrUnsupportedVersion if the binary is older.
+// If the option is passed several times, the last one applies.
+func RequireMinVersion(major, minor, patch int) OptionFunc {
+	return OptionFunc{name: "RequireMinVersion", minVersion: &VersionInfo{Major: major, Minor: minor, Patch: patch}}
+}
+
+// newVersionRequirement returns the requirement set with RequireMinVersion, or nil if unset.
+func newVersionRequirement(optionFuncs []OptionFunc) *versionRequirement {
+	var r *versionRequirement
+	for _, optionFunc := range optionFuncs {
+		if optionFunc.minVersion != nil {
+			r = &versionRequirement{min: *optionFunc.minVersion}
+		}
+	}
+	return r
+}
+
+// check returns ErrUnsupportedVersion if the binary is older than the required version.
+// The version is queried through the wrapper, so this must be called before any argument is added.
+// Failures to query the version aren't kept, so the next run tries again.
+func (r *versionRequirement) check(b *binwrapper.BinWrapper, tool string) error {
+	if r == nil {
+		return nil
+	}
+	if r.checked {
+		return r.err
+	}
+
+	v, err := versionInfo(b)
+	if err != nil {
+		return fmt.Errorf("failed to get %s version: %w", tool, err)
+	}
+	if !v.AtLeast(r.min.Major, r.min.Minor, r.min.Patch) {
+		r.err = fmt.Errorf("%w: %s %s is older than the required version %s", ErrUnsupportedVersion, tool, v, r.min)
+	}
+
+	r.checked = true
+	return r.err
+}
 
 func SetSkipDownload(isSkipDownload bool) OptionFunc {
-	return func(binWrapper *binwrapper.BinWrapper) error {
+	return NewOption("SetSkipDownload", func(binWrapper *binwrapper.BinWrapper) error {
 		skipDownload = isSkipDownload
 		return nil
-	}
+	})
+}
+
+// SetDownloadMirrors sets an ordered list of base URLs to download the libwebp release archives from.
+// Each URL must contain the archives under the same names as the official release location,
+// e.g. <url>/libwebp-1.5.0-linux-x86-64.tar.gz. When the binary is missing, the mirrors are
+// tried in order until one download succeeds.
+// Like SetVendorPath, the setting applies to all wrappers created afterwards.
+func SetDownloadMirrors(urls ...string) OptionFunc {
+	return NewOption("SetDownloadMirrors", func(binWrapper *binwrapper.BinWrapper) error {
+		downloadMirrors = urls
+		return nil
+	})
+}
+
+// SetMaxOutputBytes limits the output of the binaries that is captured in memory to n bytes,
+// such as the decoded image returned by DWebP.Run. Output written to a file or to a writer set
+// with Output is not limited. If a binary exceeds the limit, it is killed and Run returns
+// ErrOutputTooLarge, which protects long-running services from running out of memory.
+// A value of 0 or less removes the limit, which is the default.
+// Like SetVendorPath, the setting applies to all wrappers created afterwards.
+func SetMaxOutputBytes(n int64) OptionFunc {
+	return NewOption("SetMaxOutputBytes", func(binWrapper *binwrapper.BinWrapper) error {
+		maxOutputBytes = n
+		return nil
+	})
 }
 
 func SetVendorPath(path string) OptionFunc {
-	return func(binWrapper *binwrapper.BinWrapper) error {
+	return NewOption("SetVendorPath", func(binWrapper *binwrapper.BinWrapper) error {
 		dest = path
+		binWrapper.Dest(path)
 		return nil
-	}
+	})
+}
+
+// WithVendorPath sets the directory of the binaries for this instance only.
+// Unlike SetVendorPath, it doesn't change the path used by other instances,
+// so instances using binaries from different directories can run concurrently.
+func WithVendorPath(path string) OptionFunc {
+	return NewOption("WithVendorPath", func(binWrapper *binwrapper.BinWrapper) error {
+		binWrapper.Dest(path)
+		return nil
+	})
+}
+
+// WithLibWebPVersion sets the libwebp version to download for this instance only.
+// Unlike the LIBWEBP_VERSION environment variable, it doesn't change the version used by other instances.
+// The binaries are only downloaded if they are missing, so instances using different versions
+// should also use different directories set with WithVendorPath.
+// Download mirrors set with SetDownloadMirrors always use the global version.
+func WithLibWebPVersion(version string) OptionFunc {
+	return NewOption("WithLibWebPVersion", func(binWrapper *binwrapper.BinWrapper) error {
+		// The wrapper downloads the first matching source, so these take precedence over the defaults.
+		for _, src := range platformSrcs(defaultBaseURL, version) {
+			binWrapper.Src(src)
+		}
+		return nil
+	})
 }
 
 func loadDefaultFromENV() error {
@@ -60,61 +266,220 @@ func DetectUnsupportedPlatforms() {
 	}
 }
 
-func createBinWrapper(optionFuncs ...OptionFunc) *binwrapper.BinWrapper {
-	base := "https://storage.googleapis.com/downloads.webmproject.org/releases/webp/"
+// defaultBaseURL is the location of the official libwebp release archives.
+const defaultBaseURL = "https://storage.googleapis.com/downloads.webmproject.org/releases/webp/"
 
+func createBinWrapper(optionFuncs ...OptionFunc) *binwrapper.BinWrapper {
 	b := binwrapper.NewBinWrapper().AutoExe()
 
 	loadDefaultFromENV()
 
+	b.Strip(2).Dest(dest)
+
+	for _, optionFunc := range optionFuncs {
+		if optionFunc.apply != nil {
+			optionFunc.apply(b)
+		}
+	}
+
+	if skipDownload {
+		b.SkipDownload()
+	} else {
+		for _, src := range platformSrcs(defaultBaseURL, libwebpVersion) {
+			b.Src(src)
+		}
+	}
+
+	return b
+}
+
+// optionNames returns the names of the options, such as "WithVendorPath".
+func optionNames(optionFuncs []OptionFunc) []string {
+	names := make([]string, 0, len(optionFuncs))
 	for _, optionFunc := range optionFuncs {
-		optionFunc(b)
-	}
-
-	if !skipDownload {
-		b.Src(
-			binwrapper.NewSrc().
-				URL(base + "libwebp-" + libwebpVersion + "-mac-arm64.tar.gz").
-				Os("darwin").
-				Arch("arm64")).
-			Src(
-				binwrapper.NewSrc().
-					URL(base + "libwebp-" + libwebpVersion + "-mac-x86-64.tar.gz").
-					Os("darwin").
-					Arch("x64")).
-			Src(
-				binwrapper.NewSrc().
-					URL(base + "libwebp-" + libwebpVersion + "-linux-x86-32.tar.gz").
-					Os("linux").
-					Arch("x86")).
-			Src(
-				binwrapper.NewSrc().
-					URL(base + "libwebp-" + libwebpVersion + "-linux-x86-64.tar.gz").
-					Os("linux").
-					Arch("x64")).
-			Src(
-				binwrapper.NewSrc().
-					URL(base + "libwebp-" + libwebpVersion + "-linux-aarch64.tar.gz").
-					Os("linux").
-					Arch("arm64")).
-			Src(
-				binwrapper.NewSrc().
-					URL(base + "libwebp-" + libwebpVersion + "-linux-aarch64.tar.gz").
-					Os("linux").
-					Arch("aarch64")).
-			Src(
-				binwrapper.NewSrc().
-					URL(base + "libwebp-" + libwebpVersion + "-windows-x64.zip").
-					Os("win32").
-					Arch("x64")).
-			Src(
-				binwrapper.NewSrc().
-					URL(base + "libwebp-" + libwebpVersion + "-windows-x86.zip").
-					Os("win32").
-					Arch("x86"))
-	}
-
-	return b.Strip(2).Dest(dest)
+		names = append(names, optionFunc.name)
+	}
+	return names
+}
+
+// platformSrcs returns the release archive sources of the libwebp version for all supported platforms
+// below the base URL.
+func platformSrcs(base, version string) []*binwrapper.Src {
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	prefix := base + "libwebp-" + version
+	return []*binwrapper.Src{
+		binwrapper.NewSrc().URL(prefix + "-mac-arm64.tar.gz").Os("darwin").Arch("arm64"),
+		binwrapper.NewSrc().URL(prefix + "-mac-x86-64.tar.gz").Os("darwin").Arch("x64"),
+		binwrapper.NewSrc().URL(prefix + "-linux-x86-32.tar.gz").Os("linux").Arch("x86"),
+		binwrapper.NewSrc().URL(prefix + "-linux-x86-64.tar.gz").Os("linux").Arch("x64"),
+		binwrapper.NewSrc().URL(prefix + "-linux-aarch64.tar.gz").Os("linux").Arch("arm64"),
+		binwrapper.NewSrc().URL(prefix + "-linux-aarch64.tar.gz").Os("linux").Arch("aarch64"),
+		binwrapper.NewSrc().URL(prefix + "-windows-x64.zip").Os("win32").Arch("x64"),
+		binwrapper.NewSrc().URL(prefix + "-windows-x86.zip").Os("win32").Arch("x86"),
+	}
+}
+
+// prepareBinary downloads the binary if it is not present yet.
+// The mirrors are tried in order until one of them succeeds.
+// Without mirrors, the binary is downloaded from the sources of the wrapper.
+// Binaries looked up in the system PATH are never downloaded.
+func prepareBinary(b *binwrapper.BinWrapper) error {
+	if skipDownload {
+		return nil
+	}
+
+	path := b.Path()
+	if usesPATH(path) {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if len(downloadMirrors) == 0 {
+		// Running a copy of the wrapper makes it download and extract the binary,
+		// without touching the arguments of the original.
+		m := *b
+		return m.Reset().Run("-version")
+	}
+
+	var errs []error
+	for _, mirror := range downloadMirrors {
+		m := binwrapper.NewBinWrapper().AutoExe()
+		for _, src := range platformSrcs(mirror, libwebpVersion) {
+			m.Src(src)
+		}
+		m.Strip(2).Dest(filepath.Dir(path)).ExecPath(filepath.Base(path))
+
+		// Running the binary makes the wrapper download and extract it.
+		err := m.Run("-version")
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("mirror %s: %w", mirror, err))
+	}
+
+	return fmt.Errorf("failed to download binary from mirrors: %w", errors.Join(errs...))
+}
+
+// outputWriter records the first error returned by the wrapped writer,
+// so writer failures can be distinguished from failures of the binary.
+// It also counts the bytes written.
+type outputWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (o *outputWriter) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	o.n += int64(n)
+	if err != nil && o.err == nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// cappedBuffer collects the output of a binary up to max bytes.
+// Once the limit is exceeded, further output is discarded and onExceed is called once.
+// Writes never fail, so the binary is not blocked writing to a pipe nobody reads.
+// The buffer is not embedded, so io.Copy can't bypass Write through its ReadFrom method.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	max      int64
+	exceeded bool
+	onExceed func()
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.exceeded {
+		return len(p), nil
+	}
+	if int64(c.buf.Len()+len(p)) > c.max {
+		c.exceeded = true
+		c.onExceed()
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+// runBinary runs the configured binary with stdin as its standard input and kills it when ctx is done.
+// The process is started here rather than by the binary wrapper, so it is bound to ctx from the start.
+// Returns the standard output of the binary if it is not written to ow, and its standard error.
+// Cancellation is reported as ErrCanceled or ErrTimeout depending on the context error,
+// even if the binary finished before it could be killed.
+// Writer failures recorded by ow are reported as ErrOutputWrite.
+func runBinary(ctx context.Context, b *binwrapper.BinWrapper, tool string, stdin io.Reader, ow *outputWriter) ([]byte, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, contextError(err)
+	}
+
+	if err := prepareBinary(b); err != nil {
+		return nil, nil, err
+	}
+
+	runCtx, kill := context.WithCancel(ctx)
+	defer kill()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(runCtx, b.Path(), b.Args()...)
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+
+	var captured *cappedBuffer
+	switch {
+	case ow != nil:
+		cmd.Stdout = ow
+	case maxOutputBytes > 0:
+		captured = &cappedBuffer{max: maxOutputBytes, onExceed: kill}
+		cmd.Stdout = captured
+	default:
+		cmd.Stdout = &stdout
+	}
+
+	err := cmd.Run()
+	if captured != nil && captured.exceeded {
+		return nil, nil, fmt.Errorf("%w: %s wrote more than %d bytes", ErrOutputTooLarge, tool, captured.max)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, contextError(ctxErr)
+	}
+	if err != nil {
+		if ow != nil && ow.err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrOutputWrite, ow.err)
+		}
+		return nil, nil, fmt.Errorf("%s command failed: %w. stderr: %s", tool, err, stderr.Bytes())
+	}
+
+	if captured != nil {
+		return captured.buf.Bytes(), stderr.Bytes(), nil
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// usesPATH reports whether the binary path is a bare name, which happens with an empty vendor path.
+// Such binaries are looked up in the system PATH when they are run.
+func usesPATH(path string) bool {
+	return filepath.Base(path) == path
+}
+
+// contextError wraps the error of a done context in ErrTimeout or ErrCanceled.
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %w", ErrCanceled, err)
+}
+
+// checkImageSize returns ErrEmptyImage if the image has a width or height of zero or less.
+func checkImageSize(img image.Image) error {
+	if bounds := img.Bounds(); bounds.Dx() <= 0 || bounds.Dy() <= 0 {
+		return fmt.Errorf("%w: %dx%d pixels", ErrEmptyImage, bounds.Dx(), bounds.Dy())
+	}
+	return nil
 }
 
 func createReaderFromImage(img image.Image) (io.Reader, error) {
@@ -132,8 +497,29 @@ func createReaderFromImage(img image.Image) (io.Reader, error) {
 	return &buffer, nil
 }
 
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 func version(b *binwrapper.BinWrapper) (string, error) {
 	b.Reset()
+	if err := prepareBinary(b); err != nil {
+		return "", err
+	}
+
 	err := b.Run("-version")
 
 	if err != nil {
@@ -145,3 +531,12 @@ func version(b *binwrapper.BinWrapper) (string, error) {
 	version = strings.Replace(version, "\r", "", -1)
 	return version, nil
 }
+
+func versionInfo(b *binwrapper.BinWrapper) (VersionInfo, error) {
+	v, err := version(b)
+	if err != nil {
+		return VersionInfo{}, err
+	}
+
+	return ParseVersionInfo(v)
+}
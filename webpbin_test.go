@@ -0,0 +1,221 @@
+package webpwrap
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/belphemur/go-binwrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildReleaseArchive creates a gzipped tarball laid out like a libwebp release,
+// holding the given file two directory levels deep.
+func buildReleaseArchive(t *testing.T, name string, content []byte) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+
+	for _, dir := range []string{"libwebp/", "libwebp/bin/"} {
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}))
+	}
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "libwebp/bin/" + name, Mode: 0755, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	assert.Nil(t, err)
+
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	return b.Bytes()
+}
+
+func TestSetDownloadMirrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binary is a shell script")
+	}
+
+	previousDest, previousMirrors, previousSkip := dest, downloadMirrors, skipDownload
+	defer func() {
+		dest, downloadMirrors, skipDownload = previousDest, previousMirrors, previousSkip
+	}()
+	skipDownload = false
+
+	var requested []string
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, "broken")
+		http.NotFound(w, r)
+	}))
+	defer broken.Close()
+
+	archive := buildReleaseArchive(t, "cwebp", []byte("#!/bin/sh\necho 9.9.9\n"))
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, "working")
+		w.Write(archive)
+	}))
+	defer working.Close()
+
+	c := NewCWebP(SetVendorPath(t.TempDir()), SetDownloadMirrors(broken.URL, working.URL+"/releases/"))
+	version, err := c.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, "9.9.9", version)
+	assert.Equal(t, []string{"broken", "working"}, requested)
+
+	// The binary is present now and no further downloads happen.
+	_, err = c.Version()
+	assert.Nil(t, err)
+	assert.Len(t, requested, 2)
+}
+
+func TestSetDownloadMirrorsAllFailing(t *testing.T) {
+	previousDest, previousMirrors, previousSkip := dest, downloadMirrors, skipDownload
+	defer func() {
+		dest, downloadMirrors, skipDownload = previousDest, previousMirrors, previousSkip
+	}()
+	skipDownload = false
+
+	broken := httptest.NewServer(http.NotFoundHandler())
+	defer broken.Close()
+
+	c := NewCWebP(SetVendorPath(t.TempDir()), SetDownloadMirrors(broken.URL, broken.URL))
+	_, err := c.Version()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), broken.URL)
+}
+
+// writeFakeBinary writes a shell script named name to dir that prints the given version.
+func writeFakeBinary(t *testing.T, dir, name, version string) {
+	err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\necho "+version+"\n"), 0755)
+	assert.Nil(t, err)
+}
+
+func TestWithVendorPathConcurrent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binary is a shell script")
+	}
+
+	previousDest := dest
+	first, second := t.TempDir(), t.TempDir()
+	writeFakeBinary(t, first, "cwebp", "1.1.0")
+	writeFakeBinary(t, second, "cwebp", "1.2.0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for path, expected := range map[string]string{first: "1.1.0", second: "1.2.0"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				version, err := NewCWebP(WithVendorPath(path)).Version()
+				assert.Nil(t, err)
+				assert.Equal(t, expected, version)
+			}()
+		}
+	}
+	wg.Wait()
+
+	// The global vendor path is left untouched.
+	assert.Equal(t, previousDest, dest)
+}
+
+func TestSetMaxOutputBytes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binary is a shell script")
+	}
+
+	previousMax := maxOutputBytes
+	defer func() { maxOutputBytes = previousMax }()
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\nhead -c 1000000 /dev/zero\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "dwebp"), []byte(script), 0755))
+
+	img, err := NewDWebP(WithVendorPath(dir), SetMaxOutputBytes(1000)).InputFile("source.webp").Run()
+	assert.Nil(t, img)
+	assert.ErrorIs(t, err, ErrOutputTooLarge)
+
+	// Output within the limit is returned as usual.
+	img, err = NewDWebP(SetMaxOutputBytes(1 << 30)).InputFile("source.webp").Run()
+	assert.Nil(t, err)
+	assert.NotNil(t, img)
+}
+
+// srcURLs returns the URLs of the download sources of the wrapper.
+func srcURLs(b *binwrapper.BinWrapper) []string {
+	var urls []string
+	srcs := reflect.ValueOf(b).Elem().FieldByName("src")
+	for i := 0; i < srcs.Len(); i++ {
+		urls = append(urls, srcs.Index(i).Elem().FieldByName("url").String())
+	}
+	return urls
+}
+
+func TestWithLibWebPVersion(t *testing.T) {
+	previousSkip, previousVersion := skipDownload, libwebpVersion
+	defer func() { skipDownload, libwebpVersion = previousSkip, previousVersion }()
+	skipDownload = false
+
+	older := srcURLs(NewCWebP(WithLibWebPVersion("1.4.0")).BinWrapper)
+	newer := srcURLs(NewDWebP(WithLibWebPVersion("1.6.0")).BinWrapper)
+	assert.NotEqual(t, older, newer)
+
+	// The instance sources precede the default ones, so they are downloaded.
+	assert.Contains(t, older[0], "libwebp-1.4.0-")
+	assert.Contains(t, newer[0], "libwebp-1.6.0-")
+	assert.Contains(t, older[len(older)-1], "libwebp-"+libwebpVersion+"-")
+	assert.Equal(t, previousVersion, libwebpVersion)
+
+	// Skipping the download removes the instance sources as well.
+	assert.Empty(t, srcURLs(NewCWebP(WithLibWebPVersion("1.4.0"), SetSkipDownload(true)).BinWrapper))
+}
+
+func TestAppliedOptions(t *testing.T) {
+	custom := NewOption("withProxy", func(binWrapper *binwrapper.BinWrapper) error { return nil })
+
+	c := NewCWebP(WithVendorPath(t.TempDir()), WithLibWebPVersion("1.4.0"))
+	assert.Equal(t, []string{"WithVendorPath", "WithLibWebPVersion"}, c.AppliedOptions())
+
+	d := NewDWebP(WithVendorPath(t.TempDir()), custom)
+	assert.Equal(t, []string{"WithVendorPath", "withProxy"}, d.AppliedOptions())
+
+	assert.Empty(t, NewCWebP().AppliedOptions())
+}
+
+func TestRequireMinVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake binary is a shell script")
+	}
+
+	dir := t.TempDir()
+	writeFakeBinary(t, dir, "cwebp", "1.1.0")
+	writeFakeBinary(t, dir, "dwebp", "1.1.0")
+
+	var b bytes.Buffer
+	c := NewCWebP(WithVendorPath(dir), RequireMinVersion(1, 2, 0))
+	err := c.InputFile("source.jpg").Output(&b).Run()
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.ErrorContains(t, err, "cwebp 1.1.0 is older than the required version 1.2.0")
+	assert.Zero(t, b.Len())
+
+	_, err = NewDWebP(WithVendorPath(dir), RequireMinVersion(1, 1, 1)).InputFile("source.webp").Run()
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// The version is checked once per instance, so replacing the binary doesn't change the result.
+	writeFakeBinary(t, dir, "cwebp", "1.2.0")
+	assert.ErrorIs(t, c.InputFile("source.jpg").Output(&b).Run(), ErrUnsupportedVersion)
+
+	// Binaries meeting the requirement run as usual.
+	c = NewCWebP(WithVendorPath(dir), RequireMinVersion(1, 2, 0))
+	assert.Nil(t, c.InputFile("source.jpg").Output(&b).Run())
+	writeFakeBinary(t, dir, "cwebp", "1.1.0")
+	assert.Nil(t, c.InputFile("source.jpg").Output(&b).Run())
+	assert.Equal(t, []string{"WithVendorPath", "RequireMinVersion"}, c.AppliedOptions())
+
+	// The requirement only applies to the instance it was passed to.
+	assert.Nil(t, NewCWebP(WithVendorPath(dir)).InputFile("source.jpg").Output(&b).Run())
+}